@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/config"
+)
+
+func runKeys(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage("keys: missing subcommand")
+	}
+
+	switch args[0] {
+	case "generate":
+		fs := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+		alg := fs.String("alg", cfg.JWTKeyAlg, "key algorithm: EdDSA or RS256")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		kid, err := writeNewKey(cfg.JWTKeysDir, *alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("generated %s key %s in %s\n", *alg, kid, cfg.JWTKeysDir)
+		return nil
+
+	case "list":
+		ks, err := auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKID, cfg.JWTIssuer, cfg.JWTAudience)
+		if err != nil {
+			return err
+		}
+		for _, k := range ks.JWKS().Keys {
+			mark := ""
+			if k.Kid == ks.ActiveKID() {
+				mark = " (active)"
+			}
+			fmt.Printf("%s\t%s%s\n", k.Kid, k.Alg, mark)
+		}
+		return nil
+
+	default:
+		return errUsage("keys: unknown subcommand %q", args[0])
+	}
+}
+
+// loadKeys opens the signing key set, creating a first key when the
+// directory holds none so a fresh deployment can start without setup.
+func loadKeys(cfg config.Config) (*auth.KeySet, error) {
+	matches, err := filepath.Glob(filepath.Join(cfg.JWTKeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		if cfg.JWTActiveKID != "" {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID is %q but %s holds no keys", cfg.JWTActiveKID, cfg.JWTKeysDir)
+		}
+		kid, err := writeNewKey(cfg.JWTKeysDir, cfg.JWTKeyAlg)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("generated signing key %s in %s", kid, cfg.JWTKeysDir)
+	}
+
+	return auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKID, cfg.JWTIssuer, cfg.JWTAudience)
+}
+
+func writeNewKey(dir, alg string) (string, error) {
+	kid, data, err := auth.GenerateKey(alg)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, kid+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("key %s already exists", kid)
+		}
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	return kid, f.Close()
+}
@@ -2,19 +2,39 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/gin-gonic/gin"
-
-	"komiac-support-backend/internal/auth"
 	"komiac-support-backend/internal/config"
-	"komiac-support-backend/internal/http-server/routes"
 	postgres "komiac-support-backend/internal/storage"
 )
 
+const usage = `usage: komiac-support-backend <command> [args]
+
+commands:
+  serve                              run the HTTP server (default)
+  migrate up                         apply all pending migrations
+  migrate down [-steps N]            roll back the last N migrations (default 1)
+  migrate status                     list migrations and whether they are applied
+  seed depts                         insert default departments into an empty depts table
+  seed users                         create SEED_ADMIN_* / SEED_USER_* accounts if missing
+  user create [flags]                create a user account
+  user set-password -login L         set a new password (read from -password or stdin)
+  user set-role -login L -role R     change the role of an account
+  user import -file F [-dry-run]     create or update users from a CSV or XLSX file
+  keys generate [-alg EdDSA|RS256]   add a JWT signing key to JWT_KEYS_DIR
+  keys list                          list JWT signing keys and the active one
+`
+
 func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
 	cfg := config.Load()
 	if cfg.DatabaseURL == "" {
 		log.Fatal("DATABASE_URL is empty")
@@ -23,74 +43,35 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	store, err := postgres.New(ctx, cfg.DatabaseURL)
-	if err != nil {
-		log.Fatal(err)
+	var err error
+	switch args[0] {
+	case "serve":
+		err = runServe(ctx, cfg)
+	case "migrate":
+		err = runMigrate(ctx, cfg, args[1:])
+	case "seed":
+		err = runSeed(ctx, cfg, args[1:])
+	case "user":
+		err = runUser(ctx, cfg, args[1:])
+	case "keys":
+		err = runKeys(cfg, args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
-	defer func() { _ = store.Close() }()
 
-	if err := postgres.EnsureSchema(ctx, store); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := postgres.EnsureSeedDepts(ctx, store, postgres.SeedDeptsConfig{
-		Enabled: cfg.SeedDepts,
-	}); err != nil {
-		log.Fatal(err)
-	}
-
-	usersRepo := postgres.NewUsersRepo(store.DB)
-	ticketsRepo := postgres.NewTicketsRepo(store.DB)
-
-	r := gin.Default()
-	routes.Register(r, cfg, usersRepo, ticketsRepo)
-
-	log.Println("listening on :8080")
-	if err := r.Run(":8080"); err != nil {
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func seedUsers(ctx context.Context, cfg config.Config, usersRepo *postgres.UsersRepo) error {
-	if cfg.SeedAdmin.Enabled {
-		hash, err := auth.HashPassword(cfg.SeedAdmin.Password)
-		if err != nil {
-			return err
-		}
-
-		if err := usersRepo.CreateSeedSupportIfNotExists(
-			ctx,
-			cfg.SeedAdmin.Login,
-			cfg.SeedAdmin.Email,
-			hash,
-			cfg.SeedAdmin.First,
-			cfg.SeedAdmin.Last,
-			cfg.SeedAdmin.Phone,
-			cfg.SeedAdmin.Dept,
-		); err != nil {
-			return err
-		}
-	}
-
-	if cfg.SeedUser.Enabled {
-		hash, err := auth.HashPassword(cfg.SeedUser.Password)
-		if err != nil {
-			return err
-		}
-
-		if err := usersRepo.CreateSeedUserIfNotExists(
-			ctx,
-			cfg.SeedUser.Login,
-			cfg.SeedUser.Email,
-			hash,
-			cfg.SeedUser.First,
-			cfg.SeedUser.Last,
-			cfg.SeedUser.Phone,
-			cfg.SeedUser.Dept,
-		); err != nil {
-			return err
-		}
-	}
+func openStore(ctx context.Context, cfg config.Config) (*postgres.Storage, error) {
+	return postgres.New(ctx, cfg.DatabaseURL)
+}
 
-	return nil
+func errUsage(format string, a ...any) error {
+	return fmt.Errorf("%s\n\n%s", fmt.Sprintf(format, a...), usage)
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"komiac-support-backend/internal/config"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func runMigrate(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage("migrate: missing subcommand")
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	switch args[0] {
+	case "up":
+		applied, err := postgres.MigrateUp(ctx, store)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		return nil
+
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		reverted, err := postgres.MigrateDown(ctx, store, *steps)
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("nothing to roll back")
+		}
+		for _, m := range reverted {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		return nil
+
+	case "status":
+		st, err := postgres.MigrationsStatus(ctx, store)
+		if err != nil {
+			return err
+		}
+		for _, m := range st {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", m.Version, m.Name, applied)
+		}
+		return nil
+
+	default:
+		return errUsage("migrate: unknown subcommand %q", args[0])
+	}
+}
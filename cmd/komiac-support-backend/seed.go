@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/config"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func runSeed(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage("seed: missing subcommand")
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	switch args[0] {
+	case "depts":
+		if err := postgres.EnsureSeedDepts(ctx, store, postgres.SeedDeptsConfig{Enabled: true}); err != nil {
+			return err
+		}
+		fmt.Println("depts seeded")
+		return nil
+
+	case "users":
+		if err := seedUsers(ctx, cfg, postgres.NewUsersRepo(store.DB)); err != nil {
+			return err
+		}
+		fmt.Println("users seeded")
+		return nil
+
+	default:
+		return errUsage("seed: unknown subcommand %q", args[0])
+	}
+}
+
+func seedUsers(ctx context.Context, cfg config.Config, usersRepo *postgres.UsersRepo) error {
+	if seedAccountReady("SEED_ADMIN", cfg.SeedAdmin) {
+		hash, err := auth.HashPassword(cfg.SeedAdmin.Password)
+		if err != nil {
+			return err
+		}
+
+		if err := usersRepo.CreateSeedSupportIfNotExists(
+			ctx,
+			cfg.SeedAdmin.Login,
+			cfg.SeedAdmin.Email,
+			hash,
+			cfg.SeedAdmin.First,
+			cfg.SeedAdmin.Last,
+			cfg.SeedAdmin.Phone,
+			cfg.SeedAdmin.Dept,
+		); err != nil {
+			return fmt.Errorf("seed admin: %w", err)
+		}
+	}
+
+	if seedAccountReady("SEED_USER", cfg.SeedUser) {
+		hash, err := auth.HashPassword(cfg.SeedUser.Password)
+		if err != nil {
+			return err
+		}
+
+		if err := usersRepo.CreateSeedUserIfNotExists(
+			ctx,
+			cfg.SeedUser.Login,
+			cfg.SeedUser.Email,
+			hash,
+			cfg.SeedUser.First,
+			cfg.SeedUser.Last,
+			cfg.SeedUser.Phone,
+			cfg.SeedUser.Dept,
+		); err != nil {
+			return fmt.Errorf("seed user: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func seedAccountReady(prefix string, a config.SeedAccount) bool {
+	if !a.Enabled {
+		return false
+	}
+	if a.Login == "" || a.Email == "" || a.Password == "" {
+		log.Printf("%s is enabled but %s_LOGIN, %s_EMAIL or %s_PASSWORD is empty, skipping", prefix, prefix, prefix, prefix)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/attachscan"
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/blob"
+	"komiac-support-backend/internal/clamav"
+	"komiac-support-backend/internal/config"
+	"komiac-support-backend/internal/http-server/routes"
+	"komiac-support-backend/internal/mail"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func runServe(ctx context.Context, cfg config.Config) error {
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	switch cfg.DBMigrate {
+	case "auto":
+		applied, err := postgres.MigrateUp(ctx, store)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			log.Printf("applied migration %d_%s", m.Version, m.Name)
+		}
+	case "check":
+		if err := postgres.CheckSchema(ctx, store); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown DB_MIGRATE mode %q", cfg.DBMigrate)
+	}
+
+	if err := postgres.EnsureSeedDepts(ctx, store, postgres.SeedDeptsConfig{
+		Enabled: cfg.SeedDepts,
+	}); err != nil {
+		return err
+	}
+
+	usersRepo := postgres.NewUsersRepo(store.DB)
+	numbers, err := postgres.ParseTicketNumberTemplate(cfg.TicketNumberTemplate, cfg.TicketNumberPrefix)
+	if err != nil {
+		return err
+	}
+	ticketsRepo := postgres.NewTicketsRepo(store.DB, numbers)
+
+	if err := seedUsers(ctx, cfg, usersRepo); err != nil {
+		return err
+	}
+
+	mailer, err := mail.New(mail.Config(cfg.Mail))
+	if err != nil {
+		return err
+	}
+
+	authenticator, err := newAuthenticator(cfg, usersRepo)
+	if err != nil {
+		return err
+	}
+
+	keys, err := loadKeys(cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("signing tokens with key %s", keys.ActiveKID())
+
+	oidcClient, err := newOIDC(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := blob.New(ctx, blob.Config(cfg.Blob))
+	if err != nil {
+		return err
+	}
+	if err := startScanner(ctx, cfg, ticketsRepo, blobs, mailer); err != nil {
+		return err
+	}
+	sweeper := &attachscan.Sweeper{Tickets: ticketsRepo, Blobs: blobs, Interval: time.Minute}
+	go sweeper.Run(ctx)
+
+	r := gin.Default()
+	routes.Register(r, cfg, routes.Deps{
+		Users:          usersRepo,
+		Tickets:        ticketsRepo,
+		Depts:          postgres.NewDeptsRepo(store.DB),
+		Sessions:       postgres.NewSessionsRepo(store.DB),
+		PasswordResets: postgres.NewPasswordResetsRepo(store.DB),
+		Verifications:  postgres.NewEmailVerificationsRepo(store.DB),
+		LoginAttempts:  postgres.NewLoginAttemptsRepo(store.DB),
+		APIKeys:        postgres.NewAPIKeysRepo(store.DB),
+		Impersonations: postgres.NewImpersonationsRepo(store.DB),
+		Blobs:          blobs,
+		Mailer:         mailer,
+		Authenticator:  authenticator,
+		OIDC:           oidcClient,
+		Keys:           keys,
+	})
+
+	log.Println("listening on :8080")
+	return r.Run(":8080")
+}
+
+func newAuthenticator(cfg config.Config, users *postgres.UsersRepo) (auth.Authenticator, error) {
+	var chain auth.Chain
+	for _, name := range cfg.AuthBackends {
+		switch name {
+		case "password":
+			chain = append(chain, auth.PasswordAuthenticator{Users: users})
+		case "ldap":
+			if cfg.LDAP.URL == "" || cfg.LDAP.BaseDN == "" {
+				return nil, fmt.Errorf("AUTH_BACKENDS has ldap but LDAP_URL or LDAP_BASE_DN is empty")
+			}
+			groupRoles, err := auth.ParseRoleMappings(cfg.LDAP.GroupRoles)
+			if err != nil {
+				return nil, fmt.Errorf("LDAP_GROUP_ROLES: %w", err)
+			}
+			if cfg.LDAP.DefaultRole != "" && !auth.ValidRole(cfg.LDAP.DefaultRole) {
+				return nil, fmt.Errorf("LDAP_DEFAULT_ROLE: unknown role %q", cfg.LDAP.DefaultRole)
+			}
+			chain = append(chain, auth.NewLDAPAuthenticator(auth.LDAPConfig{
+				URL:                cfg.LDAP.URL,
+				StartTLS:           cfg.LDAP.StartTLS,
+				InsecureSkipVerify: cfg.LDAP.InsecureSkipVerify,
+				Timeout:            cfg.LDAP.Timeout,
+				BindDN:             cfg.LDAP.BindDN,
+				BindPassword:       cfg.LDAP.BindPassword,
+				BaseDN:             cfg.LDAP.BaseDN,
+				UserFilter:         cfg.LDAP.UserFilter,
+				AttrUsername:       cfg.LDAP.AttrUsername,
+				AttrEmail:          cfg.LDAP.AttrEmail,
+				AttrFirstName:      cfg.LDAP.AttrFirstName,
+				AttrMiddleName:     cfg.LDAP.AttrMiddleName,
+				AttrLastName:       cfg.LDAP.AttrLastName,
+				AttrPhone:          cfg.LDAP.AttrPhone,
+				AttrDept:           cfg.LDAP.AttrDept,
+				AttrGroups:         cfg.LDAP.AttrGroups,
+				GroupRoles:         groupRoles,
+				DefaultRole:        cfg.LDAP.DefaultRole,
+			}, users))
+		default:
+			return nil, fmt.Errorf("unknown auth backend %q", name)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("AUTH_BACKENDS is empty")
+	}
+	return chain, nil
+}
+
+func newOIDC(ctx context.Context, cfg config.Config) (*auth.OIDC, error) {
+	if cfg.OIDC.IssuerURL == "" {
+		return nil, nil
+	}
+	if cfg.OIDC.ClientID == "" || cfg.OIDC.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER_URL is set but OIDC_CLIENT_ID or OIDC_REDIRECT_URL is empty")
+	}
+	roleMap, err := auth.ParseRoleMappings(cfg.OIDC.RoleMap)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC_ROLE_MAP: %w", err)
+	}
+	if cfg.OIDC.DefaultRole != "" && !auth.ValidRole(cfg.OIDC.DefaultRole) {
+		return nil, fmt.Errorf("OIDC_DEFAULT_ROLE: unknown role %q", cfg.OIDC.DefaultRole)
+	}
+
+	return auth.NewOIDC(ctx, auth.OIDCConfig{
+		Provider:      cfg.OIDC.Provider,
+		IssuerURL:     cfg.OIDC.IssuerURL,
+		ClientID:      cfg.OIDC.ClientID,
+		ClientSecret:  cfg.OIDC.ClientSecret,
+		RedirectURL:   cfg.OIDC.RedirectURL,
+		Scopes:        cfg.OIDC.Scopes,
+		UsernameClaim: cfg.OIDC.UsernameClaim,
+		RoleClaim:     cfg.OIDC.RoleClaim,
+		RoleMappings:  roleMap,
+		DefaultRole:   cfg.OIDC.DefaultRole,
+		DeptClaim:     cfg.OIDC.DeptClaim,
+	})
+}
+
+func startScanner(ctx context.Context, cfg config.Config, tickets *postgres.TicketsRepo, blobs blob.Store, mailer mail.Sender) error {
+	if cfg.ClamAV.Address == "" {
+		log.Println("CLAMD_ADDRESS is empty, new attachments are not scanned and pending ones stay blocked")
+		return nil
+	}
+	if cfg.Blob.Driver == "s3" && cfg.Blob.S3QuarantineBucket == "" {
+		return fmt.Errorf("S3_QUARANTINE_BUCKET is required when CLAMD_ADDRESS is set")
+	}
+
+	quarantine, err := blob.NewQuarantine(ctx, blob.Config(cfg.Blob))
+	if err != nil {
+		return err
+	}
+	clamd := &clamav.Client{Address: cfg.ClamAV.Address, Timeout: cfg.ClamAV.Timeout}
+	if err := clamd.Ping(ctx); err != nil {
+		// Uploads stay pending until clamd answers.
+		log.Printf("clamd at %s is not reachable yet: %v", cfg.ClamAV.Address, err)
+	}
+
+	s := &attachscan.Scanner{
+		Tickets:    tickets,
+		Blobs:      blobs,
+		Quarantine: quarantine,
+		Clamd:      clamd,
+		Mailer:     mailer,
+		Interval:   cfg.ClamAV.ScanInterval,
+		RetryAfter: cfg.ClamAV.Timeout + time.Minute,
+	}
+	go s.Run(ctx)
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/config"
+	postgres "komiac-support-backend/internal/storage"
+	"komiac-support-backend/internal/userimport"
+)
+
+func runUser(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage("user: missing subcommand")
+	}
+
+	switch args[0] {
+	case "create":
+		return runUserCreate(ctx, cfg, args[1:])
+	case "set-password":
+		return runUserSetPassword(ctx, cfg, args[1:])
+	case "set-role":
+		return runUserSetRole(ctx, cfg, args[1:])
+	case "import":
+		return runUserImport(ctx, cfg, args[1:])
+	default:
+		return errUsage("user: unknown subcommand %q", args[0])
+	}
+}
+
+func runUserCreate(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	login := fs.String("login", "", "username (required)")
+	email := fs.String("email", "", "email (required)")
+	password := fs.String("password", "", "password (read from stdin if empty)")
+	first := fs.String("first", "", "first name (required)")
+	last := fs.String("last", "", "last name (required)")
+	phone := fs.String("phone", "", "phone")
+	dept := fs.String("dept", "", "department name")
+	role := fs.String("role", string(auth.RoleUser), "role: user, dept_lead, support or admin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *login == "" || *email == "" || *first == "" || *last == "" {
+		return errUsage("user create: -login, -email, -first and -last are required")
+	}
+	if !auth.ValidRole(*role) {
+		return fmt.Errorf("user create: unknown role %q", *role)
+	}
+
+	pass, err := passwordArg(*password)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(pass)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	id, err := postgres.NewUsersRepo(store.DB).Create(ctx, postgres.CreateUserParams{
+		Username:     *login,
+		Email:        *email,
+		PasswordHash: hash,
+		FirstName:    *first,
+		LastName:     *last,
+		Phone:        *phone,
+		DeptName:     *dept,
+		Role:         *role,
+	})
+	if err != nil {
+		return fmt.Errorf("user create: %w", err)
+	}
+
+	fmt.Printf("created user %s (id %d, role %s)\n", *login, id, *role)
+	return nil
+}
+
+func runUserImport(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("user import", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV or XLSX file with employees (required)")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing anything")
+	update := fs.Bool("update", false, "also change the role and email of existing accounts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errUsage("user import: -file is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	recs, err := userimport.Parse(*file, f)
+	if err != nil {
+		return fmt.Errorf("user import: %w", err)
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	rep, err := userimport.Run(ctx, postgres.NewUsersRepo(store.DB), recs, postgres.ImportOptions{DryRun: *dryRun, Update: *update})
+	if err != nil {
+		return fmt.Errorf("user import: %w", err)
+	}
+
+	for _, row := range rep.Rows {
+		detail := row.Error
+		if len(row.Changes) > 0 {
+			detail = strings.Join(row.Changes, ", ")
+		}
+		if len(row.Ignored) > 0 {
+			detail = strings.TrimPrefix(detail+"; not updated "+strings.Join(row.Ignored, ", "), "; ")
+		}
+		if row.DeptCreated != "" {
+			detail = strings.TrimPrefix(detail+"; new dept "+row.DeptCreated, "; ")
+		}
+		fmt.Printf("%5d  %-9s  %-30s  %s\n", row.Line, row.Action, row.Username, detail)
+	}
+
+	prefix := ""
+	if *dryRun {
+		prefix = "dry run: "
+	}
+	fmt.Printf("\n%s%d created, %d updated, %d unchanged, %d skipped, %d failed, %d depts created\n",
+		prefix, rep.Created, rep.Updated, rep.Unchanged, rep.Skipped, rep.Failed, len(rep.DeptsCreated))
+	return nil
+}
+
+func runUserSetPassword(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("user set-password", flag.ContinueOnError)
+	login := fs.String("login", "", "username or email (required)")
+	password := fs.String("password", "", "new password (read from stdin if empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *login == "" {
+		return errUsage("user set-password: -login is required")
+	}
+
+	pass, err := passwordArg(*password)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(pass)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	if err := postgres.NewUsersRepo(store.DB).SetPasswordByLogin(ctx, *login, hash); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user %q not found", *login)
+		}
+		return err
+	}
+
+	fmt.Printf("password updated for %s\n", *login)
+	return nil
+}
+
+func runUserSetRole(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("user set-role", flag.ContinueOnError)
+	login := fs.String("login", "", "username or email (required)")
+	role := fs.String("role", "", "role: user, dept_lead, support or admin (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *login == "" || *role == "" {
+		return errUsage("user set-role: -login and -role are required")
+	}
+	if !auth.ValidRole(*role) {
+		return fmt.Errorf("user set-role: unknown role %q", *role)
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	if err := postgres.NewUsersRepo(store.DB).SetRoleByLogin(ctx, *login, *role); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user %q not found", *login)
+		}
+		return err
+	}
+
+	fmt.Printf("role of %s set to %s\n", *login, *role)
+	return nil
+}
+
+func passwordArg(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, auth.ValidatePassword(flagValue)
+	}
+
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+
+	pass := strings.TrimRight(line, "\r\n")
+	if err := auth.ValidatePassword(pass); err != nil {
+		return "", err
+	}
+	return pass, nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+const maxAPIKeyLifetimeDays = 365
+
+func (h *Handlers) ListAPIKeys(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	items, err := h.APIKeys.ListForUser(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if items == nil {
+		items = make([]postgres.APIKey, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": items})
+}
+
+func (h *Handlers) CreateAPIKey(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || tooLong(&req.Name, 100) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required (max 100 chars)"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one scope is required"})
+		return
+	}
+	for _, s := range req.Scopes {
+		if !auth.ValidScope(s) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + s, "scopes": auth.Scopes})
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyLifetimeDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresInDays must be between 1 and 365, or 0 for no expiry"})
+		return
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+
+	p := postgres.CreateAPIKeyParams{
+		UserID:  uid,
+		Name:    req.Name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  req.Scopes,
+	}
+	resp := CreateAPIKeyResponse{
+		Key: key,
+		APIKey: postgres.APIKey{
+			Name:      req.Name,
+			Prefix:    prefix,
+			Scopes:    req.Scopes,
+			CreatedAt: time.Now().Format("15:04 02.01.2006"),
+		},
+	}
+	if req.ExpiresInDays > 0 {
+		exp := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		p.ExpiresAt = &exp
+		s := exp.Format("15:04 02.01.2006")
+		resp.APIKey.ExpiresAt = &s
+	}
+
+	resp.APIKey.ID, err = h.APIKeys.Create(c.Request.Context(), p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *Handlers) RevokeAPIKey(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	if err := h.APIKeys.Revoke(c.Request.Context(), uid, id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
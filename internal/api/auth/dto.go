@@ -1,5 +1,7 @@
 package auth
 
+import postgres "komiac-support-backend/internal/storage"
+
 type LoginRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
@@ -16,9 +18,101 @@ type LoginResponse struct {
 		Phone    string  `json:"phone,omitempty"`
 		DeptID   *int64  `json:"deptId,omitempty"`
 		DeptName *string `json:"deptName,omitempty"`
+
+		MustChangePassword bool `json:"mustChangePassword"`
 	} `json:"user"`
 }
 
 type RefreshResponse struct {
 	AccessToken string `json:"accessToken"`
 }
+
+type ForgotPasswordRequest struct {
+	Login string `json:"login"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type UpdateProfileRequest struct {
+	FirstName  *string `json:"firstName"`
+	MiddleName *string `json:"middleName"`
+	LastName   *string `json:"lastName"`
+	Phone      *string `json:"phone"`
+	Email      *string `json:"email"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type MFAChallengeResponse struct {
+	MFARequired        bool   `json:"mfaRequired"`
+	EnrollmentRequired bool   `json:"enrollmentRequired"`
+	MFAToken           string `json:"mfaToken"`
+}
+
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+type TOTPSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type MFAEnrollResponse struct {
+	LoginResponse
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+type CreateAPIKeyResponse struct {
+	Key    string          `json:"key"`
+	APIKey postgres.APIKey `json:"apiKey"`
+}
+
+type RegisterRequest struct {
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	FirstName  string `json:"firstName"`
+	MiddleName string `json:"middleName"`
+	LastName   string `json:"lastName"`
+	Phone      string `json:"phone"`
+	DeptID     *int64 `json:"deptId"`
+}
+
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+	// ReadOnly defaults to true; only an explicit false allows writes.
+	ReadOnly *bool `json:"readOnly"`
+}
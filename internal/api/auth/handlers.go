@@ -2,24 +2,68 @@ package auth
 
 import (
 	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"komiac-support-backend/internal/auth"
 	"komiac-support-backend/internal/config"
+	"komiac-support-backend/internal/mail"
 	postgres "komiac-support-backend/internal/storage"
 )
 
 const RefreshCookieName = "refresh_token"
 
 type Handlers struct {
-	Cfg   config.Config
-	Users *postgres.UsersRepo
+	Cfg            config.Config
+	Users          *postgres.UsersRepo
+	Depts          *postgres.DeptsRepo
+	Sessions       *postgres.SessionsRepo
+	Resets         *postgres.PasswordResetsRepo
+	Verifications  *postgres.EmailVerificationsRepo
+	Attempts       *postgres.LoginAttemptsRepo
+	APIKeys        *postgres.APIKeysRepo
+	Impersonations *postgres.ImpersonationsRepo
+	Mailer         mail.Sender
+	Authenticator  auth.Authenticator
+	OIDC           *auth.OIDC
+	Keys           *auth.KeySet
 }
 
-func New(cfg config.Config, users *postgres.UsersRepo) *Handlers {
-	return &Handlers{Cfg: cfg, Users: users}
+func New(
+	cfg config.Config,
+	users *postgres.UsersRepo,
+	depts *postgres.DeptsRepo,
+	sessions *postgres.SessionsRepo,
+	resets *postgres.PasswordResetsRepo,
+	verifications *postgres.EmailVerificationsRepo,
+	attempts *postgres.LoginAttemptsRepo,
+	apiKeys *postgres.APIKeysRepo,
+	impersonations *postgres.ImpersonationsRepo,
+	mailer mail.Sender,
+	authenticator auth.Authenticator,
+	oidc *auth.OIDC,
+	keys *auth.KeySet,
+) *Handlers {
+	return &Handlers{
+		Cfg:            cfg,
+		Users:          users,
+		Depts:          depts,
+		Sessions:       sessions,
+		Resets:         resets,
+		Verifications:  verifications,
+		Attempts:       attempts,
+		APIKeys:        apiKeys,
+		Impersonations: impersonations,
+		Mailer:         mailer,
+		Authenticator:  authenticator,
+		OIDC:           oidc,
+		Keys:           keys,
+	}
 }
 
 func uidFromCtx(c *gin.Context) (int64, bool) {
@@ -45,46 +89,78 @@ func (h *Handlers) Login(c *gin.Context) {
 		return
 	}
 
-	u, err := h.Users.GetByLogin(c.Request.Context(), req.Login)
+	ctx := c.Request.Context()
+	login := strings.TrimSpace(req.Login)
+
+	ipFailures, err := h.Attempts.CountIPFailures(ctx, c.ClientIP(), time.Now().Add(-h.Cfg.LoginFailureWindow))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
-			return
-		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
-
-	if err := auth.CheckPassword(u.PasswordHash, req.Password); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	if ipFailures >= h.Cfg.LoginIPMaxFailures {
+		h.recordAttempt(c, login, nil, false, "ip_blocked")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts"})
 		return
 	}
 
-	role := auth.Role(u.Role)
+	u, err := h.Users.GetByLogin(ctx, login)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if u != nil && h.rejectLocked(c, login, u) {
+		return
+	}
 
-	access, err := auth.Sign(u.ID, role, h.Cfg.AccessSecret, h.Cfg.AccessTTL)
+	authed, err := h.Authenticator.Authenticate(ctx, login, req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		switch {
+		case errors.Is(err, auth.ErrUnknownUser):
+			h.rejectLogin(c, login, u, "unknown_login", ipFailures)
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			h.rejectLogin(c, login, u, "bad_password", ipFailures)
+		case errors.Is(err, postgres.ErrExternalConflict):
+			h.recordAttempt(c, login, u, false, "external_conflict")
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("authenticate %q: %v", login, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "auth backend unavailable"})
+		}
 		return
 	}
 
-	refresh, err := auth.Sign(u.ID, role, h.Cfg.RefreshSecret, h.Cfg.RefreshTTL)
+	u = authed
+	if h.rejectInactive(c, login, u) {
+		return
+	}
+
+	if h.requireMFA(c, u) {
+		h.recordAttempt(c, login, u, true, "mfa_pending")
+		return
+	}
+
+	h.acceptLogin(c, login, u)
+	h.completeLogin(c, u)
+}
+
+func (h *Handlers) completeLogin(c *gin.Context, u *postgres.User) {
+	resp, err := h.loginResponse(c, u)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "session error"})
 		return
 	}
 
-	c.SetCookie(
-		RefreshCookieName,
-		refresh,
-		int(h.Cfg.RefreshTTL.Seconds()),
-		"/",
-		h.Cfg.CookieDomain,
-		h.Cfg.CookieSecure,
-		true,
-	)
+	c.JSON(http.StatusOK, resp)
+}
 
+func (h *Handlers) loginResponse(c *gin.Context, u *postgres.User) (LoginResponse, error) {
 	var resp LoginResponse
+
+	access, err := h.startSession(c, u)
+	if err != nil {
+		return resp, err
+	}
+
 	resp.AccessToken = access
 	resp.User.ID = u.ID
 	resp.User.Name = u.FirstName + " " + u.LastName
@@ -94,8 +170,41 @@ func (h *Handlers) Login(c *gin.Context) {
 	resp.User.Phone = u.Phone
 	resp.User.DeptID = u.DeptID
 	resp.User.DeptName = u.DeptName
+	resp.User.MustChangePassword = u.MustChangePassword
 
-	c.JSON(http.StatusOK, resp)
+	return resp, nil
+}
+
+// startSession opens a new refresh session family for u, sets the refresh
+// cookie and returns the access token.
+func (h *Handlers) startSession(c *gin.Context, u *postgres.User) (string, error) {
+	familyID, err := auth.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	jti, err := auth.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.Sessions.Create(c.Request.Context(), postgres.CreateSessionParams{
+		JTI:       jti,
+		FamilyID:  familyID,
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(h.Cfg.RefreshTTL),
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}); err != nil {
+		return "", err
+	}
+
+	access, refresh, err := h.signPair(u, familyID, jti)
+	if err != nil {
+		return "", err
+	}
+
+	h.setRefreshCookie(c, refresh)
+	return access, nil
 }
 
 func (h *Handlers) Refresh(c *gin.Context) {
@@ -105,23 +214,79 @@ func (h *Handlers) Refresh(c *gin.Context) {
 		return
 	}
 
-	claims, err := auth.Parse(rt, h.Cfg.RefreshSecret)
-	if err != nil {
+	claims, err := h.Keys.ParsePurpose(rt, auth.PurposeRefresh)
+	if err != nil || claims.ID == "" || claims.SID == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh"})
 		return
 	}
 
-	access, err := auth.Sign(claims.UID, claims.Role, h.Cfg.AccessSecret, h.Cfg.AccessTTL)
+	jti, err := auth.NewTokenID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+
+	uid, err := h.Sessions.Rotate(c.Request.Context(), claims.ID, postgres.CreateSessionParams{
+		JTI:       jti,
+		ExpiresAt: time.Now().Add(h.Cfg.RefreshTTL),
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, postgres.ErrRefreshReuse):
+			h.clearRefreshCookie(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh reuse detected"})
+		case errors.Is(err, postgres.ErrSessionRevoked):
+			h.clearRefreshCookie(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	u, err := h.Users.GetByID(c.Request.Context(), uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			h.clearRefreshCookie(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if u.DeactivatedAt != nil {
+		if err := h.Sessions.RevokeFamily(c.Request.Context(), claims.SID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		h.clearRefreshCookie(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "account deactivated"})
+		return
+	}
+
+	access, refresh, err := h.signPair(u, claims.SID, jti)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
 		return
 	}
 
+	h.setRefreshCookie(c, refresh)
 	c.JSON(http.StatusOK, RefreshResponse{AccessToken: access})
 }
 
 func (h *Handlers) Logout(c *gin.Context) {
-	c.SetCookie(RefreshCookieName, "", -1, "/", h.Cfg.CookieDomain, h.Cfg.CookieSecure, true)
+	if rt, err := c.Cookie(RefreshCookieName); err == nil && rt != "" {
+		if claims, err := h.Keys.ParsePurpose(rt, auth.PurposeRefresh); err == nil && claims.SID != "" {
+			if err := h.Sessions.RevokeFamily(c.Request.Context(), claims.SID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+		}
+	}
+
+	h.clearRefreshCookie(c)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
@@ -142,13 +307,70 @@ func (h *Handlers) Me(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"user": gin.H{
-			"id":       u.ID,
-			"name":     u.FirstName + " " + u.LastName,
-			"role":     u.Role,
-			"username": u.Username,
-			"email":    u.Email,
-		},
-	})
+	resp := gin.H{"user": profileJSON(u)}
+	if v, ok := c.Get("impersonationId"); ok {
+		im, err := h.Impersonations.Get(c.Request.Context(), v.(int64))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		resp["impersonation"] = im
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func profileJSON(u *postgres.User) gin.H {
+	return gin.H{
+		"id":           u.ID,
+		"name":         u.FirstName + " " + u.LastName,
+		"role":         u.Role,
+		"username":     u.Username,
+		"email":        u.Email,
+		"pendingEmail": u.PendingEmail,
+		"firstName":    u.FirstName,
+		"middleName":   u.MiddleName,
+		"lastName":     u.LastName,
+		"phone":        u.Phone,
+		"deptId":       u.DeptID,
+		"deptName":     u.DeptName,
+		"mfaEnabled":   u.TOTPEnabled,
+		"permissions":  auth.Role(u.Role).Permissions(),
+
+		"mustChangePassword": u.MustChangePassword,
+	}
+}
+
+func (h *Handlers) signPair(u *postgres.User, sid, jti string) (string, string, error) {
+	role := auth.Role(u.Role)
+
+	access, err := h.Keys.Sign(auth.Claims{UID: u.ID, Role: role, SID: sid, PasswordChange: u.MustChangePassword}, h.Cfg.AccessTTL)
+	if err != nil {
+		return "", "", err
+	}
+
+	rc := auth.Claims{UID: u.ID, Role: role, SID: sid, Purpose: auth.PurposeRefresh}
+	rc.ID = jti
+	refresh, err := h.Keys.Sign(rc, h.Cfg.RefreshTTL)
+	if err != nil {
+		return "", "", err
+	}
+
+	return access, refresh, nil
+}
+
+func (h *Handlers) setRefreshCookie(c *gin.Context, token string) {
+	c.SetCookie(
+		RefreshCookieName,
+		token,
+		int(h.Cfg.RefreshTTL.Seconds()),
+		"/",
+		h.Cfg.CookieDomain,
+		h.Cfg.CookieSecure,
+		true,
+	)
+}
+
+func (h *Handlers) clearRefreshCookie(c *gin.Context) {
+	c.SetCookie(RefreshCookieName, "", -1, "/", h.Cfg.CookieDomain, h.Cfg.CookieSecure, true)
 }
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func (h *Handlers) Impersonate(c *gin.Context) {
+	actorID, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || tooLong(&req.Reason, 500) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required (max 500 chars)"})
+		return
+	}
+	readOnly := req.ReadOnly == nil || *req.ReadOnly
+
+	if id == actorID {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot impersonate yourself"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	u, err := h.Users.GetByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	switch {
+	case u.DeactivatedAt != nil:
+		c.JSON(http.StatusConflict, gin.H{"error": "account deactivated"})
+		return
+	case u.RegistrationStatus != "":
+		c.JSON(http.StatusConflict, gin.H{"error": "registration not completed"})
+		return
+	case auth.Role(u.Role).CanAny(auth.PermUserImpersonate, auth.PermUserManage):
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot impersonate an administrator"})
+		return
+	}
+
+	impID, err := h.Impersonations.Start(ctx, postgres.StartImpersonationParams{
+		ActorID:   actorID,
+		TargetID:  u.ID,
+		Reason:    req.Reason,
+		ReadOnly:  readOnly,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		ExpiresAt: time.Now().Add(h.Cfg.ImpersonationTTL),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	token, err := h.Keys.Sign(auth.Claims{
+		UID:           u.ID,
+		Role:          auth.Role(u.Role),
+		ActorUID:      actorID,
+		Impersonation: impID,
+		ReadOnly:      readOnly,
+	}, h.Cfg.ImpersonationTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+
+	im, err := h.Impersonations.Get(ctx, impID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"accessToken":   token,
+		"impersonation": im,
+		"user":          profileJSON(u),
+	})
+}
+
+func (h *Handlers) EndImpersonation(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	if err := h.Impersonations.End(c.Request.Context(), id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found or already ended"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (h *Handlers) ListImpersonations(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 200 {
+		size = 50
+	}
+
+	p := postgres.ListImpersonationsParams{Limit: size, Offset: (page - 1) * size}
+	for key, dst := range map[string]*int64{"actorId": &p.ActorID, "targetId": &p.TargetID} {
+		v := c.Query(key)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad " + key})
+			return
+		}
+		*dst = id
+	}
+
+	items, total, err := h.Impersonations.List(c.Request.Context(), p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if items == nil {
+		items = make([]postgres.Impersonation, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"impersonations": items,
+		"total":          total,
+		"page":           page,
+		"pageSize":       size,
+	})
+}
@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+const recoveryCodesCount = 10
+
+var errBadSecondFactor = errors.New("invalid code")
+
+func (h *Handlers) mfaRequiredFor(role string) bool {
+	return slices.Contains(h.Cfg.MFARequiredRoles, role)
+}
+
+// requireMFA answers the login with an MFA challenge instead of tokens when
+// the account has TOTP enabled or its role is required to enrol.
+func (h *Handlers) requireMFA(c *gin.Context, u *postgres.User) bool {
+	purpose, token, err := h.mfaChallenge(u)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return true
+	}
+	if purpose == "" {
+		return false
+	}
+
+	c.JSON(http.StatusOK, MFAChallengeResponse{
+		MFARequired:        purpose == auth.PurposeMFA,
+		EnrollmentRequired: purpose == auth.PurposeMFAEnroll,
+		MFAToken:           token,
+	})
+	return true
+}
+
+// mfaChallenge signs the challenge token u has to redeem before a session is
+// opened; purpose is empty when no second factor is needed.
+func (h *Handlers) mfaChallenge(u *postgres.User) (purpose, token string, err error) {
+	switch {
+	case u.TOTPEnabled:
+		purpose = auth.PurposeMFA
+	case h.mfaRequiredFor(u.Role):
+		purpose = auth.PurposeMFAEnroll
+	default:
+		return "", "", nil
+	}
+
+	token, err = h.Keys.Sign(auth.Claims{UID: u.ID, Role: auth.Role(u.Role), Purpose: purpose}, h.Cfg.MFAChallengeTTL)
+	if err != nil {
+		return "", "", err
+	}
+	return purpose, token, nil
+}
+
+func (h *Handlers) challengeUser(c *gin.Context, token, purpose string) (*postgres.User, bool) {
+	claims, err := h.Keys.ParsePurpose(token, purpose)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid mfa token"})
+		return nil, false
+	}
+
+	u, err := h.Users.GetByID(c.Request.Context(), claims.UID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid mfa token"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+	if u.DeactivatedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account deactivated"})
+		return nil, false
+	}
+	return u, true
+}
+
+func (h *Handlers) LoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	u, ok := h.challengeUser(c, req.MFAToken, auth.PurposeMFA)
+	if !ok {
+		return
+	}
+	if h.rejectLocked(c, "", u) {
+		return
+	}
+
+	if err := h.verifySecondFactor(c.Request.Context(), u, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, errBadSecondFactor) {
+			h.rejectLogin(c, "", u, "bad_mfa_code", 0)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	h.acceptLogin(c, "", u)
+	h.completeLogin(c, u)
+}
+
+func (h *Handlers) LoginMFASetup(c *gin.Context) {
+	var req MFAEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	u, ok := h.challengeUser(c, req.MFAToken, auth.PurposeMFAEnroll)
+	if !ok {
+		return
+	}
+
+	h.setupTOTP(c, u)
+}
+
+func (h *Handlers) LoginMFAEnroll(c *gin.Context) {
+	var req MFAEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	u, ok := h.challengeUser(c, req.MFAToken, auth.PurposeMFAEnroll)
+	if !ok {
+		return
+	}
+
+	if h.rejectLocked(c, "", u) {
+		return
+	}
+
+	codes, ok := h.enableTOTP(c, u, req.Code)
+	if !ok {
+		return
+	}
+
+	h.acceptLogin(c, "", u)
+
+	resp, err := h.loginResponse(c, u)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "session error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAEnrollResponse{LoginResponse: resp, RecoveryCodes: codes})
+}
+
+func (h *Handlers) TOTPSetup(c *gin.Context) {
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	h.setupTOTP(c, u)
+}
+
+func (h *Handlers) TOTPEnable(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, ok := h.enableTOTP(c, u, req.Code)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+func (h *Handlers) TOTPDisable(c *gin.Context) {
+	var req DisableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !u.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa not enabled"})
+		return
+	}
+	if h.mfaRequiredFor(u.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "mfa is required for your role"})
+		return
+	}
+	// Directory and SSO accounts have no local password; for them the code
+	// alone confirms the change.
+	if u.AuthSource == postgres.AuthSourceLocal {
+		if err := auth.CheckPassword(u.PasswordHash, req.Password); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "wrong password"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	if err := h.verifySecondFactor(ctx, u, req.Code, ""); err != nil {
+		if errors.Is(err, errBadSecondFactor) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if err := h.Users.DisableTOTP(ctx, u.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (h *Handlers) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	u, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if !u.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa not enabled"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if err := h.verifySecondFactor(ctx, u, req.Code, ""); err != nil {
+		if errors.Is(err, errBadSecondFactor) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+	if err := h.Users.ReplaceRecoveryCodes(ctx, u.ID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+func (h *Handlers) currentUser(c *gin.Context) (*postgres.User, bool) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	u, err := h.Users.GetByID(c.Request.Context(), uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+	return u, true
+}
+
+func (h *Handlers) setupTOTP(c *gin.Context, u *postgres.User) {
+	if u.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa already enabled"})
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+	if err := h.Users.SetTOTPSecret(c.Request.Context(), u.ID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, TOTPSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(h.Cfg.TOTPIssuer, u.Username, secret),
+	})
+}
+
+func (h *Handlers) enableTOTP(c *gin.Context, u *postgres.User, code string) ([]string, bool) {
+	if u.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa already enabled"})
+		return nil, false
+	}
+	if u.TOTPSecret == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa setup not started"})
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+
+	step, ok := auth.VerifyTOTP(u.TOTPSecret, code, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return nil, false
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return nil, false
+	}
+
+	if err := h.Users.EnableTOTP(ctx, u.ID, hashes); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "mfa setup not started"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+	if err := h.Users.UseTOTPStep(ctx, u.ID, step); err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+
+	return codes, true
+}
+
+func (h *Handlers) verifySecondFactor(ctx context.Context, u *postgres.User, code, recoveryCode string) error {
+	if recoveryCode = auth.NormalizeRecoveryCode(recoveryCode); recoveryCode != "" {
+		err := h.Users.UseRecoveryCode(ctx, u.ID, auth.HashToken(recoveryCode))
+		if err == sql.ErrNoRows {
+			return errBadSecondFactor
+		}
+		return err
+	}
+
+	if !u.TOTPEnabled || strings.TrimSpace(code) == "" {
+		return errBadSecondFactor
+	}
+
+	step, ok := auth.VerifyTOTP(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return errBadSecondFactor
+	}
+
+	err := h.Users.UseTOTPStep(ctx, u.ID, step)
+	if err == sql.ErrNoRows {
+		return errBadSecondFactor
+	}
+	return err
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.NewRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashToken(auth.NormalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"komiac-support-backend/internal/auth"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStatePath   = "/auth/oidc"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+func (h *Handlers) OIDCLogin(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sso disabled"})
+		return
+	}
+
+	state, err := auth.NewTokenID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+	nonce, err := auth.NewTokenID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	signed, err := auth.SignOIDCState(h.Keys, auth.OIDCState{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		Redirect: safeRedirect(c.Query("redirect")),
+	}, oidcStateTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, signed, int(oidcStateTTL.Seconds()), oidcStatePath, h.Cfg.CookieDomain, h.Cfg.CookieSecure, true)
+	c.Redirect(http.StatusFound, h.OIDC.AuthCodeURL(state, nonce, verifier))
+}
+
+// OIDCCallback only sets the refresh cookie; the SPA obtains its access token
+// through /auth/refresh after the redirect.
+func (h *Handlers) OIDCCallback(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sso disabled"})
+		return
+	}
+
+	raw, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, oidcStatePath, h.Cfg.CookieDomain, h.Cfg.CookieSecure, true)
+
+	st, err := auth.ParseOIDCState(h.Keys, raw)
+	if err != nil || st.State == "" || c.Query("state") != st.State {
+		h.ssoFailed(c, "invalid_state")
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		log.Printf("oidc provider error: %s: %s", e, c.Query("error_description"))
+		h.ssoFailed(c, "provider_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	id, err := h.OIDC.Exchange(ctx, c.Query("code"), st.Verifier, st.Nonce)
+	if err != nil {
+		log.Printf("oidc callback: %v", err)
+		h.ssoFailed(c, "exchange_failed")
+		return
+	}
+	if id.Email == "" || id.FirstName == "" || id.LastName == "" {
+		log.Printf("oidc subject %s lacks email or name claims", id.Subject)
+		h.ssoFailed(c, "missing_claims")
+		return
+	}
+	if !id.EmailVerified {
+		log.Printf("oidc subject %s has an unverified email", id.Subject)
+		h.ssoFailed(c, "email_unverified")
+		return
+	}
+	if id.Username == "" {
+		id.Username = id.Email
+	}
+
+	u, err := h.Users.UpsertExternal(ctx, postgres.ExternalUserParams{
+		Source:     auth.AuthSourceOIDC,
+		ExternalID: h.OIDC.Provider() + ":" + id.Subject,
+		Username:   id.Username,
+		Email:      id.Email,
+		FirstName:  id.FirstName,
+		MiddleName: id.MiddleName,
+		LastName:   id.LastName,
+		Phone:      id.Phone,
+		DeptName:   id.Dept,
+		Role:       id.Role,
+	})
+	if err != nil {
+		if errors.Is(err, postgres.ErrExternalConflict) {
+			h.recordAttempt(c, id.Username, nil, false, "external_conflict")
+			h.ssoFailed(c, "account_exists")
+			return
+		}
+		log.Printf("oidc provision %s: %v", id.Subject, err)
+		h.ssoFailed(c, "provision_failed")
+		return
+	}
+
+	if u.LockedUntil != nil {
+		h.recordAttempt(c, id.Username, u, false, "locked")
+		h.ssoFailed(c, "locked")
+		return
+	}
+
+	if u.DeactivatedAt != nil {
+		h.recordAttempt(c, id.Username, u, false, "deactivated")
+		h.ssoFailed(c, "deactivated")
+		return
+	}
+
+	if u.RegistrationStatus != "" {
+		h.recordAttempt(c, id.Username, u, false, u.RegistrationStatus)
+		h.ssoFailed(c, u.RegistrationStatus)
+		return
+	}
+
+	// The SPA finishes the second factor through /auth/login/mfa or
+	// /auth/login/mfa/enroll with the token from the fragment.
+	purpose, token, err := h.mfaChallenge(u)
+	if err != nil {
+		h.ssoFailed(c, "token_error")
+		return
+	}
+	if purpose != "" {
+		h.recordAttempt(c, id.Username, u, true, "mfa_pending")
+		frag := url.Values{"mfaToken": {token}, "redirect": {st.Redirect}}
+		if purpose == auth.PurposeMFAEnroll {
+			frag.Set("enroll", "1")
+		}
+		c.Redirect(http.StatusFound, h.Cfg.AppBaseURL+"/login/mfa#"+frag.Encode())
+		return
+	}
+
+	if _, err := h.startSession(c, u); err != nil {
+		h.ssoFailed(c, "session_error")
+		return
+	}
+	h.acceptLogin(c, id.Username, u)
+
+	c.Redirect(http.StatusFound, h.Cfg.AppBaseURL+st.Redirect)
+}
+
+func (h *Handlers) ssoFailed(c *gin.Context, reason string) {
+	c.Redirect(http.StatusFound, h.Cfg.AppBaseURL+"/login?error=sso_failed&reason="+url.QueryEscape(reason))
+}
+
+func safeRedirect(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.Contains(p, `\`) {
+		return "/"
+	}
+	return p
+}
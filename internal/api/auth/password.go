@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/mail"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func (h *Handlers) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	login := strings.TrimSpace(req.Login)
+	if login == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+	since := time.Now().Add(-time.Hour)
+
+	n, err := h.Resets.CountRequestsByIP(ctx, ip, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if n >= h.Cfg.PasswordResetPerIP {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+		return
+	}
+
+	u, err := h.Users.GetByLogin(ctx, login)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	var uid *int64
+	if u != nil {
+		uid = &u.ID
+	}
+	if err := h.Resets.LogRequest(ctx, uid, ip); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	// The response never reveals whether the account exists: the token and
+	// the mail are handled off the request path so that every branch answers
+	// in about the same time.
+	if u != nil && u.AuthSource == postgres.AuthSourceLocal && u.DeactivatedAt == nil && u.RegistrationStatus == "" {
+		go h.sendPasswordReset(context.WithoutCancel(ctx), u, since)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// sendPasswordReset issues a reset token for u and mails the link, unless
+// the account has used up its requests for the window starting at since.
+func (h *Handlers) sendPasswordReset(ctx context.Context, u *postgres.User, since time.Time) {
+	n, err := h.Resets.CountRequestsByUser(ctx, u.ID, since)
+	if err != nil {
+		log.Printf("password reset for user %d: %v", u.ID, err)
+		return
+	}
+	if n > h.Cfg.PasswordResetPerAccount {
+		return
+	}
+
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		log.Printf("password reset for user %d: %v", u.ID, err)
+		return
+	}
+	if err := h.Resets.CreateToken(ctx, u.ID, hash, time.Now().Add(h.Cfg.PasswordResetTTL)); err != nil {
+		log.Printf("password reset for user %d: %v", u.ID, err)
+		return
+	}
+
+	link := strings.TrimRight(h.Cfg.AppBaseURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+	if err := h.Mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Восстановление пароля",
+		Body: fmt.Sprintf(
+			"Здравствуйте, %s!\n\nЧтобы задать новый пароль, перейдите по ссылке:\n%s\n\nСсылка действует %d мин. Если вы не запрашивали восстановление, просто проигнорируйте это письмо.\n",
+			u.FirstName, link, int(h.Cfg.PasswordResetTTL.Minutes()),
+		),
+	}); err != nil {
+		log.Printf("password reset mail to user %d: %v", u.ID, err)
+	}
+}
+
+func (h *Handlers) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	req.Token = strings.TrimSpace(req.Token)
+	if req.Token == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token and password required"})
+		return
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+		return
+	}
+
+	if _, err := h.Resets.Consume(c.Request.Context(), auth.HashToken(req.Token), hash); err != nil {
+		if errors.Is(err, postgres.ErrResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	h.clearRefreshCookie(c)
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/mail"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func (h *Handlers) UpdateMe(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	p := postgres.UpdateProfileParams{
+		FirstName:  trimPtr(req.FirstName),
+		MiddleName: trimPtr(req.MiddleName),
+		LastName:   trimPtr(req.LastName),
+		Phone:      trimPtr(req.Phone),
+	}
+	if (p.FirstName != nil && *p.FirstName == "") || (p.LastName != nil && *p.LastName == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "firstName/lastName cannot be empty"})
+		return
+	}
+	if tooLong(p.FirstName, 50) || tooLong(p.MiddleName, 50) || tooLong(p.LastName, 50) || tooLong(p.Phone, 20) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "value too long"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	u, err := h.Users.GetByID(ctx, uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	newEmail := ""
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if !auth.ValidEmail(email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+			return
+		}
+		if !strings.EqualFold(email, u.Email) {
+			if !h.allowedEmailDomain(email) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "email domain not allowed"})
+				return
+			}
+			taken, err := h.Users.EmailTaken(ctx, email, uid)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			if taken {
+				c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+				return
+			}
+			newEmail = email
+		}
+	}
+
+	if err := h.Users.UpdateProfile(ctx, uid, p); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if newEmail != "" {
+		if err := h.sendEmailVerification(ctx, u, newEmail); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+
+	u, err = h.Users.GetByID(ctx, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": profileJSON(u)})
+}
+
+func (h *Handlers) ChangePassword(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	u, err := h.Users.GetByID(ctx, uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if u.AuthSource != postgres.AuthSourceLocal {
+		c.JSON(http.StatusConflict, gin.H{"error": "password is managed by " + u.AuthSource})
+		return
+	}
+	if err := auth.CheckPassword(u.PasswordHash, req.CurrentPassword); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "wrong current password"})
+		return
+	}
+	if err := auth.ValidatePassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+		return
+	}
+
+	if err := h.Users.SetPassword(ctx, uid, hash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := h.Sessions.RevokeAllForUser(ctx, uid, sidFromCtx(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (h *Handlers) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Token) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	uid, err := h.Verifications.Consume(ctx, auth.HashToken(strings.TrimSpace(req.Token)))
+	if err != nil {
+		switch {
+		case errors.Is(err, postgres.ErrVerificationInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		case errors.Is(err, postgres.ErrEmailTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	status, err := h.Users.CompleteVerification(ctx, uid, h.Cfg.Registration.RequireApproval)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if status == postgres.RegistrationPendingApproval {
+		h.notifyApprovers(ctx, uid)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true, "registrationStatus": status})
+}
+
+func (h *Handlers) sendEmailVerification(ctx context.Context, u *postgres.User, email string) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := h.Verifications.Create(ctx, u.ID, email, hash, time.Now().Add(h.Cfg.EmailVerificationTTL)); err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(h.Cfg.AppBaseURL, "/") + "/verify-email?token=" + url.QueryEscape(token)
+	if err := h.Mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Подтверждение адреса электронной почты",
+		Body: fmt.Sprintf(
+			"Здравствуйте, %s!\n\nЧтобы подтвердить адрес %s, перейдите по ссылке:\n%s\n",
+			u.FirstName, email, link,
+		),
+	}); err != nil {
+		log.Printf("email verification mail to user %d: %v", u.ID, err)
+	}
+	return nil
+}
+
+func trimPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := strings.TrimSpace(*s)
+	return &v
+}
+
+func tooLong(s *string, max int) bool {
+	return s != nil && len([]rune(*s)) > max
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/mail"
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func (h *Handlers) RegistrationInfo(c *gin.Context) {
+	rc := h.Cfg.Registration
+	domains := rc.EmailDomains
+	if domains == nil {
+		domains = make([]string, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":         rc.Enabled,
+		"requireApproval": rc.RequireApproval,
+		"emailDomains":    domains,
+	})
+}
+
+func (h *Handlers) Register(c *gin.Context) {
+	rc := h.Cfg.Registration
+	if !rc.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "registration disabled"})
+		return
+	}
+
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	req.FirstName = strings.TrimSpace(req.FirstName)
+	req.MiddleName = strings.TrimSpace(req.MiddleName)
+	req.LastName = strings.TrimSpace(req.LastName)
+	req.Phone = strings.TrimSpace(req.Phone)
+
+	switch {
+	case req.Username == "" || len(req.Username) > 50:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required (max 50 chars)"})
+		return
+	case strings.Contains(req.Username, "@"):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username must not contain @"})
+		return
+	case !auth.ValidEmail(req.Email):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	case !h.allowedEmailDomain(req.Email):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email domain not allowed"})
+		return
+	case req.FirstName == "" || req.LastName == "" || tooLong(&req.FirstName, 50) || tooLong(&req.LastName, 50) || tooLong(&req.MiddleName, 50):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "firstName and lastName are required (max 50 chars)"})
+		return
+	case len(req.Phone) > 20:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone too long"})
+		return
+	case req.DeptID != nil && *req.DeptID <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad deptId"})
+		return
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+
+	n, err := h.Users.CountRegistrationsByIP(ctx, ip, time.Now().Add(-time.Hour))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if n >= rc.PerIP {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+		return
+	}
+
+	if req.DeptID != nil {
+		if _, err := h.Depts.Get(ctx, *req.DeptID); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "dept not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+
+	if err := h.Users.PurgeStaleRegistration(ctx, req.Username, req.Email, time.Now().Add(-h.Cfg.EmailVerificationTTL)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if taken, err := h.Users.UsernameTaken(ctx, req.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "username already in use"})
+		return
+	}
+	if taken, err := h.Users.EmailTaken(ctx, req.Email, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+		return
+	}
+
+	id, err := h.Users.Create(ctx, postgres.CreateUserParams{
+		Username:           req.Username,
+		Email:              req.Email,
+		PasswordHash:       hash,
+		FirstName:          req.FirstName,
+		MiddleName:         req.MiddleName,
+		LastName:           req.LastName,
+		Phone:              req.Phone,
+		DeptID:             req.DeptID,
+		Role:               string(auth.RoleUser),
+		RegistrationStatus: postgres.RegistrationPendingVerification,
+		RegistrationIP:     ip,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	u, err := h.Users.GetByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := h.sendEmailVerification(ctx, u, u.Email); err != nil {
+		log.Printf("registration of user %d: start email verification: %v", id, err)
+		// Free the username and email so the client can simply retry.
+		if err := h.Users.DeletePendingRegistration(context.WithoutCancel(ctx), id); err != nil {
+			log.Printf("registration of user %d: delete: %v", id, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not start email verification, try again later"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ok":                 true,
+		"registrationStatus": u.RegistrationStatus,
+		"requireApproval":    rc.RequireApproval,
+	})
+}
+
+func (h *Handlers) allowedEmailDomain(email string) bool {
+	domains := h.Cfg.Registration.EmailDomains
+	if len(domains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	domain := strings.ToLower(email[at+1:])
+	return slices.ContainsFunc(domains, func(d string) bool {
+		return strings.EqualFold(strings.TrimPrefix(d, "@"), domain)
+	})
+}
+
+// notifyApprovers tells everyone who can manage users that a verified
+// registration is waiting for them. Failures are only logged.
+func (h *Handlers) notifyApprovers(ctx context.Context, uid int64) {
+	u, err := h.Users.GetByID(ctx, uid)
+	if err != nil {
+		log.Printf("notify approvers for user %d: %v", uid, err)
+		return
+	}
+
+	var roles []string
+	for _, r := range auth.RolesWith(auth.PermUserManage) {
+		roles = append(roles, string(r))
+	}
+	emails, err := h.Users.ActiveEmailsByRole(ctx, roles)
+	if err != nil {
+		log.Printf("notify approvers for user %d: %v", uid, err)
+		return
+	}
+
+	dept := "—"
+	if u.DeptName != nil {
+		dept = *u.DeptName
+	}
+	link := strings.TrimRight(h.Cfg.AppBaseURL, "/") + fmt.Sprintf("/admin/users/%d", u.ID)
+	for _, to := range emails {
+		if err := h.Mailer.Send(ctx, mail.Message{
+			To:      to,
+			Subject: "Новая заявка на регистрацию",
+			Body: fmt.Sprintf(
+				"Пользователь %s %s (%s, %s) зарегистрировался и ожидает подтверждения.\nОтдел: %s\n\n%s\n",
+				u.LastName, u.FirstName, u.Username, u.Email, dept, link,
+			),
+		}); err != nil {
+			log.Printf("registration notice to %s: %v", to, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	postgres "komiac-support-backend/internal/storage"
+)
+
+func sidFromCtx(c *gin.Context) string {
+	v, _ := c.Get("sid")
+	s, _ := v.(string)
+	return s
+}
+
+func (h *Handlers) ListSessions(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	items, err := h.Sessions.ListActive(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if items == nil {
+		items = make([]postgres.Session, 0)
+	}
+
+	sid := sidFromCtx(c)
+	for i := range items {
+		items[i].Current = sid != "" && items[i].ID == sid
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": items})
+}
+
+func (h *Handlers) RevokeSession(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	if err := h.Sessions.RevokeUserFamily(c.Request.Context(), uid, id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if id == sidFromCtx(c) {
+		h.clearRefreshCookie(c)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handlers) RevokeAllSessions(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	except := ""
+	if c.Query("keepCurrent") == "true" {
+		except = sidFromCtx(c)
+	}
+
+	if err := h.Sessions.RevokeAllForUser(c.Request.Context(), uid, except); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if except == "" {
+		h.clearRefreshCookie(c)
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	postgres "komiac-support-backend/internal/storage"
+)
+
+const loginBaseDelay = 250 * time.Millisecond
+
+func (h *Handlers) recordAttempt(c *gin.Context, login string, u *postgres.User, success bool, reason string) {
+	a := postgres.LoginAttempt{
+		Login:     login,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Success:   success,
+		Reason:    reason,
+	}
+	if u != nil {
+		a.UserID = &u.ID
+		if a.Login == "" {
+			a.Login = u.Username
+		}
+	}
+
+	if err := h.Attempts.Record(c.Request.Context(), a); err != nil {
+		log.Printf("record login attempt: %v", err)
+	}
+}
+
+// rejectLocked turns a locked account away before its password is checked.
+// The answer is the same 401 a wrong password gets.
+func (h *Handlers) rejectLocked(c *gin.Context, login string, u *postgres.User) bool {
+	if u.LockedUntil == nil {
+		return false
+	}
+
+	h.recordAttempt(c, login, u, false, "locked")
+	h.loginDelay(c, u.FailedLogins+1)
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	return true
+}
+
+func (h *Handlers) rejectInactive(c *gin.Context, login string, u *postgres.User) bool {
+	switch {
+	case u.DeactivatedAt != nil:
+		h.recordAttempt(c, login, u, false, "deactivated")
+		c.JSON(http.StatusForbidden, gin.H{"error": "account deactivated"})
+	case u.RegistrationStatus != "":
+		h.recordAttempt(c, login, u, false, u.RegistrationStatus)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":              "registration not completed",
+			"registrationStatus": u.RegistrationStatus,
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// rejectLogin counts a failed attempt, waits progressively longer the more
+// failures the account or address has accumulated and answers 401.
+func (h *Handlers) rejectLogin(c *gin.Context, login string, u *postgres.User, reason string, ipFailures int) {
+	failures := ipFailures + 1
+
+	if u != nil {
+		if _, err := h.Users.RegisterLoginFailure(c.Request.Context(), u.ID, h.Cfg.LoginMaxFailures, h.Cfg.LoginLockout); err != nil {
+			log.Printf("register login failure for user %d: %v", u.ID, err)
+		}
+		failures = max(failures, u.FailedLogins+1)
+	}
+
+	h.recordAttempt(c, login, u, false, reason)
+	h.loginDelay(c, failures)
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+}
+
+func (h *Handlers) acceptLogin(c *gin.Context, login string, u *postgres.User) {
+	if u.FailedLogins > 0 {
+		if err := h.Users.ResetLoginFailures(c.Request.Context(), u.ID); err != nil {
+			log.Printf("reset login failures for user %d: %v", u.ID, err)
+		}
+	}
+	h.recordAttempt(c, login, u, true, "ok")
+}
+
+func (h *Handlers) loginDelay(c *gin.Context, failures int) {
+	if failures <= 1 || h.Cfg.LoginMaxDelay <= 0 {
+		return
+	}
+
+	d := loginBaseDelay << min(failures-2, 16)
+	d = min(d, h.Cfg.LoginMaxDelay)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-c.Request.Context().Done():
+	}
+}
@@ -0,0 +1,17 @@
+package depts
+
+type DeptRequest struct {
+	Name  *string `json:"name"`
+	Phone *string `json:"phone"`
+	// TicketPrefix fills {DEPT} in ticket numbers; "" clears it.
+	TicketPrefix *string `json:"ticketPrefix"`
+	ParentID     *int64  `json:"parentId"`
+	HeadUserID   *int64  `json:"headUserId"`
+}
+
+type PublicDept struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	ParentID *int64 `json:"parentId,omitempty"`
+}
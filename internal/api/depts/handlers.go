@@ -0,0 +1,239 @@
+package depts
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/storage"
+)
+
+type Handlers struct {
+	depts *storage.DeptsRepo
+	users *storage.UsersRepo
+}
+
+func New(depts *storage.DeptsRepo, users *storage.UsersRepo) *Handlers {
+	return &Handlers{depts: depts, users: users}
+}
+
+func (h *Handlers) ListPublic(c *gin.Context) {
+	items, err := h.depts.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	out := make([]PublicDept, 0, len(items))
+	for _, d := range items {
+		out = append(out, PublicDept{ID: d.ID, Name: d.Name, Path: d.Path, ParentID: d.ParentID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"depts": out})
+}
+
+func (h *Handlers) List(c *gin.Context) {
+	items, err := h.depts.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if items == nil {
+		items = make([]storage.Dept, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"depts": items})
+}
+
+func (h *Handlers) Get(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	d, err := h.depts.Get(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dept": d})
+}
+
+func (h *Handlers) Create(c *gin.Context) {
+	var req DeptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	if req.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if !h.validate(c, &req) {
+		return
+	}
+
+	id, err := h.depts.Create(c.Request.Context(), params(req))
+	if err != nil {
+		h.writeErr(c, err)
+		return
+	}
+
+	h.respond(c, http.StatusCreated, id)
+}
+
+func (h *Handlers) Update(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	var req DeptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	if req.ParentID != nil && *req.ParentID == id {
+		c.JSON(http.StatusConflict, gin.H{"error": storage.ErrDeptCycle.Error()})
+		return
+	}
+	if !h.validate(c, &req) {
+		return
+	}
+
+	if err := h.depts.Update(c.Request.Context(), id, params(req)); err != nil {
+		h.writeErr(c, err)
+		return
+	}
+
+	h.respond(c, http.StatusOK, id)
+}
+
+func (h *Handlers) Delete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	if err := h.depts.Delete(c.Request.Context(), id); err != nil {
+		h.writeErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// validate normalises req and checks that referenced rows exist. A parentId
+// or headUserId of 0 clears the link.
+func (h *Handlers) validate(c *gin.Context, req *DeptRequest) bool {
+	ctx := c.Request.Context()
+
+	if req.Name != nil {
+		*req.Name = strings.TrimSpace(*req.Name)
+		if *req.Name == "" || len([]rune(*req.Name)) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required (max 100 chars)"})
+			return false
+		}
+	}
+	if req.Phone != nil {
+		*req.Phone = strings.TrimSpace(*req.Phone)
+		if len(*req.Phone) > 20 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "phone too long"})
+			return false
+		}
+	}
+
+	if req.TicketPrefix != nil {
+		*req.TicketPrefix = strings.ToUpper(strings.TrimSpace(*req.TicketPrefix))
+		if !validTicketPrefix(*req.TicketPrefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ticketPrefix must be up to 10 latin letters or digits"})
+			return false
+		}
+	}
+
+	if req.ParentID != nil && *req.ParentID != 0 {
+		if _, err := h.depts.Get(ctx, *req.ParentID); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "parent dept not found"})
+				return false
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return false
+		}
+	}
+
+	if req.HeadUserID != nil && *req.HeadUserID != 0 {
+		u, err := h.users.GetByID(ctx, *req.HeadUserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "head user not found"})
+				return false
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return false
+		}
+		if u.DeactivatedAt != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "head user is deactivated"})
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handlers) respond(c *gin.Context, status int, id int64) {
+	d, err := h.depts.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(status, gin.H{"dept": d})
+}
+
+func (h *Handlers) writeErr(c *gin.Context, err error) {
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, storage.ErrDeptNameTaken),
+		errors.Is(err, storage.ErrDeptCycle),
+		errors.Is(err, storage.ErrDeptInUse),
+		errors.Is(err, storage.ErrDeptPrefix):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	}
+}
+
+func params(req DeptRequest) storage.DeptParams {
+	return storage.DeptParams{
+		Name:         req.Name,
+		Phone:        req.Phone,
+		TicketPrefix: req.TicketPrefix,
+		ParentID:     req.ParentID,
+		HeadUserID:   req.HeadUserID,
+	}
+}
+
+func validTicketPrefix(s string) bool {
+	if len(s) > 10 {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,26 @@
+package depts
+
+import "testing"
+
+func TestValidTicketPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		{"", true},
+		{"IT", true},
+		{"ACC2", true},
+		{"ABCDEFGHIJ", true},
+		{"ABCDEFGHIJK", false},
+		{"it", false},
+		{"IT-1", false},
+		{"IT ", false},
+		{"{seq}", false},
+		{"ОТД", false},
+	}
+	for _, tt := range tests {
+		if got := validTicketPrefix(tt.prefix); got != tt.want {
+			t.Errorf("validTicketPrefix(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
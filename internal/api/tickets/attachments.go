@@ -0,0 +1,294 @@
+package tickets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/blob"
+	"komiac-support-backend/internal/storage"
+)
+
+const maxFilesPerRequest = 10
+
+// bindUpload binds a JSON or multipart body, capping the request size so a
+// multipart upload cannot exceed maxFilesPerRequest full-size files.
+func (h *Handlers) bindUpload(c *gin.Context, req any) bool {
+	limit := maxFilesPerRequest*h.cfg.Attachments.MaxSize + 1<<20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	if err := c.ShouldBind(req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request too large"})
+			return false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad body"})
+		return false
+	}
+	return true
+}
+
+// storeUploads validates the "files" parts of a multipart request and writes
+// them to blob storage. On failure it answers the request and removes
+// anything already stored.
+func (h *Handlers) storeUploads(c *gin.Context) ([]storage.NewAttachment, bool) {
+	form := c.Request.MultipartForm
+	if form == nil {
+		return nil, true
+	}
+	files := form.File["files"]
+	if len(files) > maxFilesPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d files per request", maxFilesPerRequest)})
+		return nil, false
+	}
+
+	var total int64
+	for _, fh := range files {
+		if fh.Size > h.cfg.Attachments.MaxSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large", "file": fh.Filename, "maxSize": h.cfg.Attachments.MaxSize})
+			return nil, false
+		}
+		total += fh.Size
+	}
+	if quota := h.cfg.Attachments.TicketQuota; quota > 0 && total > quota {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": storage.ErrAttachmentQuota.Error()})
+		return nil, false
+	}
+
+	out := make([]storage.NewAttachment, 0, len(files))
+	for _, fh := range files {
+		a, err := h.storeUpload(c.Request.Context(), fh)
+		if err != nil {
+			h.discardUploads(out)
+			var typeErr *fileTypeError
+			if errors.As(err, &typeErr) {
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "file type not allowed", "file": fh.Filename, "contentType": typeErr.contentType})
+				return nil, false
+			}
+			log.Printf("attachments: store %q: %v", fh.Filename, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+			return nil, false
+		}
+		out = append(out, a)
+	}
+	return out, true
+}
+
+type fileTypeError struct{ contentType string }
+
+func (e *fileTypeError) Error() string { return "file type " + e.contentType + " not allowed" }
+
+func (h *Handlers) storeUpload(ctx context.Context, fh *multipart.FileHeader) (storage.NewAttachment, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return storage.NewAttachment{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return storage.NewAttachment{}, err
+	}
+	head = head[:n]
+
+	contentType := sniffType(head)
+	if !slices.Contains(h.cfg.Attachments.AllowedTypes, contentType) {
+		return storage.NewAttachment{}, &fileTypeError{contentType: contentType}
+	}
+
+	key, err := auth.NewTokenID()
+	if err != nil {
+		return storage.NewAttachment{}, err
+	}
+	if err := h.blobs.Put(ctx, key, io.MultiReader(bytes.NewReader(head), f), fh.Size, contentType); err != nil {
+		return storage.NewAttachment{}, err
+	}
+
+	scan := storage.ScanPending
+	if h.cfg.ClamAV.Address == "" {
+		scan = storage.ScanClean
+	}
+	return storage.NewAttachment{
+		FileName:    cleanFileName(fh.Filename),
+		ContentType: contentType,
+		Size:        fh.Size,
+		StorageKey:  key,
+		ScanStatus:  scan,
+	}, nil
+}
+
+// discardUploads removes blobs whose database rows were never written. It
+// runs detached from the request so a cancelled upload still cleans up.
+func (h *Handlers) discardUploads(files []storage.NewAttachment) {
+	for _, f := range files {
+		if err := h.blobs.Delete(context.Background(), f.StorageKey); err != nil {
+			log.Printf("attachments: delete %s: %v", f.StorageKey, err)
+		}
+	}
+}
+
+// sniffType ignores the client's Content-Type and file extension.
+func sniffType(head []byte) string {
+	t, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return t
+}
+
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+func attachmentURLs(items []storage.Attachment, base string) []storage.Attachment {
+	if items == nil {
+		return make([]storage.Attachment, 0)
+	}
+	for i := range items {
+		items[i].URL = base + strconv.FormatInt(items[i].ID, 10)
+	}
+	return items
+}
+
+// withAttachments hangs each attachment off the message it was posted with.
+func withAttachments(msgs []storage.TicketMessage, items []storage.Attachment) {
+	byMsg := map[int64][]storage.Attachment{}
+	for _, a := range items {
+		if a.MessageID != nil {
+			byMsg[*a.MessageID] = append(byMsg[*a.MessageID], a)
+		}
+	}
+	for i := range msgs {
+		msgs[i].Attachments = byMsg[msgs[i].ID]
+	}
+}
+
+func (h *Handlers) ListAttachments(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	if !h.canSeeTicket(c, id) {
+		return
+	}
+
+	h.listAttachments(c, id, seesInternal(c), fmt.Sprintf("/tickets/%d/attachments/", id))
+}
+
+func (h *Handlers) DownloadAttachment(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	if !h.canSeeTicket(c, id) {
+		return
+	}
+
+	h.downloadAttachment(c, id, seesInternal(c))
+}
+
+func (h *Handlers) ListMyAttachments(c *gin.Context) {
+	uid, id, ok := reporterTicket(c)
+	if !ok || !h.ownsTicket(c, id, uid) {
+		return
+	}
+
+	h.listAttachments(c, id, false, fmt.Sprintf("/tickets/my/%d/attachments/", id))
+}
+
+func (h *Handlers) DownloadMyAttachment(c *gin.Context) {
+	uid, id, ok := reporterTicket(c)
+	if !ok || !h.ownsTicket(c, id, uid) {
+		return
+	}
+
+	h.downloadAttachment(c, id, false)
+}
+
+func (h *Handlers) listAttachments(c *gin.Context, ticketID int64, withInternal bool, base string) {
+	items, err := h.tickets.ListAttachments(c.Request.Context(), ticketID, withInternal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"attachments": attachmentURLs(items, base)})
+}
+
+func (h *Handlers) downloadAttachment(c *gin.Context, ticketID int64, withInternal bool) {
+	attID, err := strconv.ParseInt(c.Param("attachmentId"), 10, 64)
+	if err != nil || attID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	a, err := h.tickets.GetAttachment(c.Request.Context(), ticketID, attID, withInternal)
+	if err != nil {
+		writeTicketErr(c, err)
+		return
+	}
+	switch a.ScanStatus {
+	case storage.ScanClean:
+	case storage.ScanInfected:
+		c.JSON(http.StatusGone, gin.H{"error": "attachment quarantined", "scanStatus": a.ScanStatus})
+		return
+	case storage.ScanFailed:
+		c.JSON(http.StatusConflict, gin.H{"error": "attachment could not be scanned", "scanStatus": a.ScanStatus})
+		return
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "attachment is being scanned", "scanStatus": a.ScanStatus})
+		return
+	}
+
+	rc, err := h.blobs.Open(c.Request.Context(), a.StorageKey)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		log.Printf("attachments: open %s: %v", a.StorageKey, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+	defer func() { _ = rc.Close() }()
+
+	c.DataFromReader(http.StatusOK, a.Size, a.ContentType, rc, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName}),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-store",
+	})
+}
@@ -4,17 +4,28 @@ type AssignTicketRequest struct {
 	AssigneeID int64 `json:"assigneeId"`
 }
 
+// AddMessageRequest and CreateTicketRequest also arrive as multipart forms
+// with the attachments in "files".
 type AddMessageRequest struct {
-	Message string `json:"message"`
+	Message    string `json:"message" form:"message"`
+	Visibility string `json:"visibility" form:"visibility"`
 }
 
 type CreateTicketRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Priority    string `json:"priority"`
+	Title       string `json:"title" form:"title"`
+	Description string `json:"description" form:"description"`
+	Priority    string `json:"priority" form:"priority"`
 }
 
 type ReplyTicketRequest struct {
 	AssigneeID int64  `json:"assigneeId"`
 	Reply      string `json:"reply"`
 }
+
+type ReopenTicketRequest struct {
+	Reason string `json:"reason"`
+}
+
+type TransitionTicketRequest struct {
+	Status string `json:"status"`
+}
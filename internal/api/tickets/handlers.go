@@ -2,21 +2,33 @@ package tickets
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/blob"
+	"komiac-support-backend/internal/config"
 	"komiac-support-backend/internal/storage"
 )
 
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
 type Handlers struct {
+	cfg     config.Config
 	tickets *storage.TicketsRepo
+	blobs   blob.Store
 }
 
-func New(tickets *storage.TicketsRepo) *Handlers {
-	return &Handlers{tickets: tickets}
+func New(cfg config.Config, tickets *storage.TicketsRepo, blobs blob.Store) *Handlers {
+	return &Handlers{cfg: cfg, tickets: tickets, blobs: blobs}
 }
 
 func uidFromCtx(c *gin.Context) (int64, bool) {
@@ -35,32 +47,75 @@ func uidFromCtx(c *gin.Context) (int64, bool) {
 	}
 }
 
-func roleFromCtx(c *gin.Context) string {
+func roleFromCtx(c *gin.Context) auth.Role {
 	v, _ := c.Get("role")
 	s, _ := v.(string)
-	return s
+	return auth.Role(s)
+}
+
+// seesInternal reports whether the caller may read internal notes. Only
+// support staff can; dept leads see department tickets but are reporters too.
+func seesInternal(c *gin.Context) bool {
+	return roleFromCtx(c).Can(auth.PermTicketReply)
+}
+
+// deptScope returns the caller's id when they may only see tickets raised in
+// their own department, and 0 when they may see every ticket.
+func deptScope(c *gin.Context) (int64, bool) {
+	if roleFromCtx(c).Can(auth.PermTicketViewAll) {
+		return 0, true
+	}
+	return uidFromCtx(c)
+}
+
+// canSeeTicket answers 404 when a department-scoped caller asks for a ticket
+// outside their department.
+func (h *Handlers) canSeeTicket(c *gin.Context, id int64) bool {
+	leadID, ok := deptScope(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return false
+	}
+	if leadID == 0 {
+		return true
+	}
+
+	in, err := h.tickets.InDeptOf(c.Request.Context(), id, leadID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return false
+	}
+	if !in {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return false
+	}
+	return true
 }
 
 func (h *Handlers) ListTickets(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	leadID, ok := deptScope(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	page, ok := pageParams(c)
+	if !ok {
 		return
 	}
 
-	items, err := h.tickets.ListTickets(c.Request.Context(), storage.ListTicketsParams{
-		Tab: c.Query("tab"),
-		Q:   c.Query("q"),
+	res, err := h.tickets.ListTickets(c.Request.Context(), storage.ListTicketsParams{
+		Tab:    c.Query("tab"),
+		Q:      c.Query("q"),
+		DeptOf: leadID,
+		Page:   page,
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
 
-	if items == nil {
-		items = make([]storage.TicketListItem, 0)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"tickets": items})
+	writeTicketPage(c, res)
 }
 
 func (h *Handlers) ListMyTickets(c *gin.Context) {
@@ -70,25 +125,21 @@ func (h *Handlers) ListMyTickets(c *gin.Context) {
 		return
 	}
 
-	items, err := h.tickets.ListMyTickets(c.Request.Context(), uid)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	page, ok := pageParams(c)
+	if !ok {
 		return
 	}
 
-	if items == nil {
-		items = make([]storage.TicketListItem, 0)
+	res, err := h.tickets.ListMyTickets(c.Request.Context(), uid, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"tickets": items})
+	writeTicketPage(c, res)
 }
 
 func (h *Handlers) GetMyTicket(c *gin.Context) {
-	if roleFromCtx(c) != "user" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-		return
-	}
-
 	uid, ok := uidFromCtx(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -115,17 +166,16 @@ func (h *Handlers) GetMyTicket(c *gin.Context) {
 }
 
 func (h *Handlers) GetTicket(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-		return
-	}
-
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || id <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
 		return
 	}
 
+	if !h.canSeeTicket(c, id) {
+		return
+	}
+
 	t, err := h.tickets.GetTicket(c.Request.Context(), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -139,12 +189,52 @@ func (h *Handlers) GetTicket(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"ticket": t})
 }
 
-func (h *Handlers) AssignTicket(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+// GetTicketByNumber serves staff through the usual visibility rules and
+// everyone else through their own tickets only.
+func (h *Handlers) GetTicketByNumber(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	number := strings.TrimSpace(c.Param("number"))
+	if number == "" || len(number) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad number"})
+		return
+	}
+
+	role := roleFromCtx(c)
+	staff := role.Can(auth.PermTicketViewAll) || role.Can(auth.PermTicketViewDept)
+
+	var reporterID int64
+	if !staff {
+		reporterID = uid
+	}
+	id, err := h.tickets.TicketIDByNumber(c.Request.Context(), number, reporterID)
+	if err != nil {
+		writeTicketErr(c, err)
+		return
+	}
+
+	var t storage.TicketDetail
+	if staff {
+		if !h.canSeeTicket(c, id) {
+			return
+		}
+		t, err = h.tickets.GetTicket(c.Request.Context(), id)
+	} else {
+		t, err = h.tickets.GetMyTicket(c.Request.Context(), uid, id)
+	}
+	if err != nil {
+		writeTicketErr(c, err)
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{"ticket": t})
+}
+
+func (h *Handlers) AssignTicket(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || id <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
@@ -159,11 +249,7 @@ func (h *Handlers) AssignTicket(c *gin.Context) {
 
 	t, err := h.tickets.AssignTicket(c.Request.Context(), id, req.AssigneeID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		writeTicketErr(c, err)
 		return
 	}
 
@@ -171,11 +257,6 @@ func (h *Handlers) AssignTicket(c *gin.Context) {
 }
 
 func (h *Handlers) AddMessage(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-		return
-	}
-
 	uid, ok := uidFromCtx(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -189,17 +270,37 @@ func (h *Handlers) AddMessage(c *gin.Context) {
 	}
 
 	var req AddMessageRequest
-	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Message) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "bad body"})
+	if !h.bindUpload(c, &req) {
+		return
+	}
+	switch req.Visibility {
+	case "":
+		req.Visibility = storage.MessagePublic
+	case storage.MessagePublic, storage.MessageInternal:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visibility must be public or internal"})
+		return
+	}
+	files, ok := h.storeUploads(c)
+	if !ok {
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" && len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message or files required"})
 		return
 	}
 
 	if err := h.tickets.AddMessage(c.Request.Context(), storage.AddMessageParams{
-		TicketID: id,
-		AuthorID: uid,
-		Message:  strings.TrimSpace(req.Message),
+		TicketID:        id,
+		AuthorID:        uid,
+		Message:         req.Message,
+		Visibility:      req.Visibility,
+		Attachments:     files,
+		AttachmentQuota: h.cfg.Attachments.TicketQuota,
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		h.discardUploads(files)
+		writeTicketErr(c, err)
 		return
 	}
 
@@ -207,11 +308,6 @@ func (h *Handlers) AddMessage(c *gin.Context) {
 }
 
 func (h *Handlers) CreateTicket(c *gin.Context) {
-	if roleFromCtx(c) != "user" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-		return
-	}
-
 	uid, ok := uidFromCtx(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -219,8 +315,7 @@ func (h *Handlers) CreateTicket(c *gin.Context) {
 	}
 
 	var req CreateTicketRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "bad body"})
+	if !h.bindUpload(c, &req) {
 		return
 	}
 
@@ -235,15 +330,23 @@ func (h *Handlers) CreateTicket(c *gin.Context) {
 	if req.Priority == "" {
 		req.Priority = "medium"
 	}
+	files, ok := h.storeUploads(c)
+	if !ok {
+		return
+	}
 
 	t, err := h.tickets.CreateTicket(c.Request.Context(), storage.CreateTicketParams{
-		Title:       req.Title,
-		Description: req.Description,
-		Priority:    req.Priority,
-		UserID:      uid,
+		Title:           req.Title,
+		Description:     req.Description,
+		Priority:        req.Priority,
+		UserID:          uid,
+		Attachments:     files,
+		AttachmentQuota: h.cfg.Attachments.TicketQuota,
+		SLA:             h.cfg.TicketSLA[req.Priority],
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		h.discardUploads(files)
+		writeTicketErr(c, err)
 		return
 	}
 
@@ -251,18 +354,21 @@ func (h *Handlers) CreateTicket(c *gin.Context) {
 }
 
 func (h *Handlers) ListMessages(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-		return
-	}
-
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || id <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
 		return
 	}
 
-	items, err := h.tickets.ListMessages(c.Request.Context(), id)
+	if !h.canSeeTicket(c, id) {
+		return
+	}
+
+	h.listMessages(c, id, seesInternal(c), fmt.Sprintf("/tickets/%d/attachments/", id))
+}
+
+func (h *Handlers) listMessages(c *gin.Context, ticketID int64, withInternal bool, attachmentBase string) {
+	items, err := h.tickets.ListMessages(c.Request.Context(), ticketID, withInternal)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -271,12 +377,20 @@ func (h *Handlers) ListMessages(c *gin.Context) {
 		items = make([]storage.TicketMessage, 0)
 	}
 
+	files, err := h.tickets.ListAttachments(c.Request.Context(), ticketID, withInternal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	withAttachments(items, attachmentURLs(files, attachmentBase))
+
 	c.JSON(http.StatusOK, gin.H{"messages": items})
 }
 
 func (h *Handlers) ReplyTicket(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
@@ -298,9 +412,9 @@ func (h *Handlers) ReplyTicket(c *gin.Context) {
 		return
 	}
 
-	t, err := h.tickets.SaveSupportReply(c.Request.Context(), id, req.AssigneeID, req.Reply)
+	t, err := h.tickets.SaveSupportReply(c.Request.Context(), id, req.AssigneeID, uid, req.Reply)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		writeTicketErr(c, err)
 		return
 	}
 
@@ -308,8 +422,9 @@ func (h *Handlers) ReplyTicket(c *gin.Context) {
 }
 
 func (h *Handlers) CloseTicket(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
@@ -319,11 +434,131 @@ func (h *Handlers) CloseTicket(c *gin.Context) {
 		return
 	}
 
-	t, err := h.tickets.CloseTicket(c.Request.Context(), id)
+	t, err := h.tickets.CloseTicket(c.Request.Context(), id, uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		writeTicketErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": t})
+}
+
+func (h *Handlers) TransitionTicket(c *gin.Context) {
+	uid, ok := uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	var req TransitionTicketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad body"})
+		return
+	}
+	req.Status = strings.TrimSpace(req.Status)
+	if !storage.ValidTicketStatus(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status"})
+		return
+	}
+	if req.Status == storage.TicketClosed && !roleFromCtx(c).Can(auth.PermTicketClose) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	t, err := h.tickets.Transition(c.Request.Context(), id, req.Status, uid)
+	if err != nil {
+		writeTicketErr(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"ticket": t})
 }
+
+// pageParams reads ?limit=&cursor=&sort=&order=&total=. Sorting defaults to
+// newest first; a cursor must come from a page with the same sort and order.
+func pageParams(c *gin.Context) (storage.TicketPageParams, bool) {
+	p := storage.TicketPageParams{Sort: c.DefaultQuery("sort", "created")}
+	if !storage.ValidTicketSort(p.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of created, updated, priority, status, sla"})
+		return p, false
+	}
+
+	switch c.Query("order") {
+	case "":
+		// Oldest SLA first is the useful default; everything else newest first.
+		p.Desc = p.Sort != "sla"
+	case "asc":
+	case "desc":
+		p.Desc = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return p, false
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return p, false
+	}
+	p.Limit = min(limit, maxPageSize)
+
+	if v := c.Query("total"); v != "" {
+		total, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad total"})
+			return p, false
+		}
+		p.WithTotal = total
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		cur, err := storage.DecodeTicketCursor(v)
+		if err != nil || cur.Sort != p.Sort || cur.Desc != p.Desc {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad cursor"})
+			return p, false
+		}
+		p.Cursor = &cur
+	}
+	return p, true
+}
+
+func writeTicketPage(c *gin.Context, res storage.TicketPage) {
+	if res.Items == nil {
+		res.Items = make([]storage.TicketListItem, 0)
+	}
+
+	out := gin.H{"tickets": res.Items, "nextCursor": nil}
+	if res.NextCursor != "" {
+		out["nextCursor"] = res.NextCursor
+	}
+	if res.Total != nil {
+		out["total"] = *res.Total
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func writeTicketErr(c *gin.Context, err error) {
+	var te *storage.TransitionError
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.As(err, &te):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   te.Error(),
+			"status":  te.From,
+			"allowed": storage.TicketTransitions(te.From),
+		})
+	case errors.Is(err, storage.ErrTicketFinished), errors.Is(err, storage.ErrReopenWindowExpired):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrAttachmentQuota):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	}
+}
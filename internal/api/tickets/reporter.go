@@ -0,0 +1,126 @@
+package tickets
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/storage"
+)
+
+// reporterTicket resolves the caller and the ticket id for the /tickets/my
+// routes; ownership itself is checked by the repo.
+func reporterTicket(c *gin.Context) (uid, id int64, ok bool) {
+	uid, ok = uidFromCtx(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return 0, 0, false
+	}
+	return uid, id, true
+}
+
+// ownsTicket answers 404 unless uid raised the ticket.
+func (h *Handlers) ownsTicket(c *gin.Context, id, uid int64) bool {
+	mine, err := h.tickets.IsReporter(c.Request.Context(), id, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return false
+	}
+	if !mine {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return false
+	}
+	return true
+}
+
+func (h *Handlers) ListMyMessages(c *gin.Context) {
+	uid, id, ok := reporterTicket(c)
+	if !ok || !h.ownsTicket(c, id, uid) {
+		return
+	}
+
+	h.listMessages(c, id, false, fmt.Sprintf("/tickets/my/%d/attachments/", id))
+}
+
+func (h *Handlers) AddMyMessage(c *gin.Context) {
+	uid, id, ok := reporterTicket(c)
+	if !ok {
+		return
+	}
+
+	var req AddMessageRequest
+	if !h.bindUpload(c, &req) {
+		return
+	}
+	files, ok := h.storeUploads(c)
+	if !ok {
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" && len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message or files required"})
+		return
+	}
+
+	if err := h.tickets.AddReporterMessage(c.Request.Context(), storage.AddMessageParams{
+		TicketID:        id,
+		AuthorID:        uid,
+		Message:         req.Message,
+		Attachments:     files,
+		AttachmentQuota: h.cfg.Attachments.TicketQuota,
+	}); err != nil {
+		h.discardUploads(files)
+		writeTicketErr(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handlers) ConfirmMyTicket(c *gin.Context) {
+	uid, id, ok := reporterTicket(c)
+	if !ok {
+		return
+	}
+
+	if err := h.tickets.ConfirmResolution(c.Request.Context(), id, uid); err != nil {
+		writeTicketErr(c, err)
+		return
+	}
+
+	h.GetMyTicket(c)
+}
+
+func (h *Handlers) ReopenMyTicket(c *gin.Context) {
+	uid, id, ok := reporterTicket(c)
+	if !ok {
+		return
+	}
+
+	var req ReopenTicketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad body"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason required"})
+		return
+	}
+
+	if err := h.tickets.Reopen(c.Request.Context(), id, uid, req.Reason, h.cfg.TicketReopenWindow); err != nil {
+		writeTicketErr(c, err)
+		return
+	}
+
+	h.GetMyTicket(c)
+}
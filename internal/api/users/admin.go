@@ -0,0 +1,470 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/mail"
+	"komiac-support-backend/internal/storage"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+func uidFromCtx(c *gin.Context) (int64, bool) {
+	v, ok := c.Get("uid")
+	if !ok {
+		return 0, false
+	}
+
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int64:
+		return x, true
+	default:
+		return 0, false
+	}
+}
+
+func (h *Handlers) ListUsers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultPageSize)))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > maxPageSize {
+		size = defaultPageSize
+	}
+
+	p := storage.SearchUsersParams{
+		Q:            c.Query("q"),
+		Role:         c.Query("role"),
+		Registration: c.Query("registration"),
+		Limit:        size,
+		Offset:       (page - 1) * size,
+	}
+	if p.Role != "" && !auth.ValidRole(p.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+	switch p.Registration {
+	case "", "none", storage.RegistrationPendingVerification, storage.RegistrationPendingApproval:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad registration"})
+		return
+	}
+	if v := c.Query("deptId"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad deptId"})
+			return
+		}
+		p.DeptID = id
+	}
+	if v := c.Query("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad active"})
+			return
+		}
+		p.Active = &active
+	}
+
+	items, total, err := h.users.Search(c.Request.Context(), p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if items == nil {
+		items = make([]storage.UserListItem, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":    items,
+		"total":    total,
+		"page":     page,
+		"pageSize": size,
+	})
+}
+
+func (h *Handlers) GetUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	u, err := h.users.GetByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	stats, err := h.tickets.StatsForUser(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": userJSON(u), "tickets": stats})
+}
+
+func (h *Handlers) TicketStats(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	stats, err := h.tickets.StatsForUser(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tickets": stats})
+}
+
+func (h *Handlers) CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	req.FirstName = strings.TrimSpace(req.FirstName)
+	req.MiddleName = strings.TrimSpace(req.MiddleName)
+	req.LastName = strings.TrimSpace(req.LastName)
+	req.Phone = strings.TrimSpace(req.Phone)
+	if req.Role == "" {
+		req.Role = string(auth.RoleUser)
+	}
+
+	switch {
+	case req.Username == "" || len(req.Username) > 50:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required (max 50 chars)"})
+		return
+	case !auth.ValidEmail(req.Email):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	case req.FirstName == "" || req.LastName == "" || len([]rune(req.FirstName)) > 50 || len([]rune(req.LastName)) > 50 || len([]rune(req.MiddleName)) > 50:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "firstName and lastName are required (max 50 chars)"})
+		return
+	case len(req.Phone) > 20:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone too long"})
+		return
+	case !auth.ValidRole(req.Role):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	case req.Invite && req.TemporaryPassword != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invite and temporaryPassword are mutually exclusive"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if !h.checkDept(c, req.DeptID) {
+		return
+	}
+	if taken, err := h.users.UsernameTaken(ctx, req.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "username already in use"})
+		return
+	}
+	if taken, err := h.users.EmailTaken(ctx, req.Email, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+		return
+	}
+
+	password := req.TemporaryPassword
+	generated := false
+	if password == "" {
+		var err error
+		if password, err = auth.NewTemporaryPassword(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		generated = true
+	} else if err := auth.ValidatePassword(password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"})
+		return
+	}
+
+	id, err := h.users.Create(ctx, storage.CreateUserParams{
+		Username:           req.Username,
+		Email:              req.Email,
+		PasswordHash:       hash,
+		FirstName:          req.FirstName,
+		MiddleName:         req.MiddleName,
+		LastName:           req.LastName,
+		Phone:              req.Phone,
+		DeptID:             req.DeptID,
+		Role:               req.Role,
+		MustChangePassword: !req.Invite,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	u, err := h.users.GetByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	resp := gin.H{"user": userJSON(u)}
+	switch {
+	case req.Invite:
+		if err := h.sendInvite(ctx, u); err != nil {
+			log.Printf("invite user %d: %v", u.ID, err)
+			resp["inviteSent"] = false
+		} else {
+			resp["inviteSent"] = true
+		}
+	case generated:
+		resp["temporaryPassword"] = password
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *Handlers) UpdateUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	if req.Role != nil && !auth.ValidRole(*req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+	if req.Phone != nil {
+		*req.Phone = strings.TrimSpace(*req.Phone)
+		if len(*req.Phone) > 20 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "phone too long"})
+			return
+		}
+	}
+	if req.DeptID != nil && *req.DeptID != 0 && !h.checkDept(c, req.DeptID) {
+		return
+	}
+	if uid, _ := uidFromCtx(c); uid == id && req.Role != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot change your own role"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.users.AdminUpdate(ctx, id, storage.AdminUpdateUserParams{
+		Role:   req.Role,
+		DeptID: req.DeptID,
+		Phone:  req.Phone,
+	}); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	u, err := h.users.GetByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": userJSON(u)})
+}
+
+func (h *Handlers) DeactivateUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+	if uid, _ := uidFromCtx(c); uid == id {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot deactivate yourself"})
+		return
+	}
+
+	if err := h.users.Deactivate(c.Request.Context(), id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (h *Handlers) ReactivateUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	if err := h.users.Reactivate(c.Request.Context(), id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// LinkExternal lets an admin attach a directory or SSO identity to an
+// existing account; logins never link accounts by username or email.
+func (h *Handlers) LinkExternal(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	var req LinkExternalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	req.ExternalID = strings.TrimSpace(req.ExternalID)
+	if req.Source != auth.AuthSourceLDAP && req.Source != auth.AuthSourceOIDC {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown source"})
+		return
+	}
+	if req.ExternalID == "" || len(req.ExternalID) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad externalId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.users.LinkExternal(ctx, id, req.Source, req.ExternalID); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		case errors.Is(err, storage.ErrExternalConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "identity already linked to another account"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	u, err := h.users.GetByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": userJSON(u)})
+}
+
+func (h *Handlers) checkDept(c *gin.Context, id *int64) bool {
+	if id == nil {
+		return true
+	}
+	if *id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad deptId"})
+		return false
+	}
+
+	if _, err := h.depts.Get(c.Request.Context(), *id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dept not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return false
+	}
+	return true
+}
+
+func (h *Handlers) sendInvite(ctx context.Context, u *storage.User) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := h.resets.CreateToken(ctx, u.ID, hash, time.Now().Add(h.cfg.UserInviteTTL)); err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(h.cfg.AppBaseURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+	return h.mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Приглашение в службу поддержки",
+		Body: fmt.Sprintf(
+			"Здравствуйте, %s!\n\nДля вас создана учётная запись %s. Чтобы задать пароль, перейдите по ссылке:\n%s\n\nСсылка действует %d дн.\n",
+			u.FirstName, u.Username, link, int(h.cfg.UserInviteTTL.Hours()/24),
+		),
+	})
+}
+
+func userJSON(u *storage.User) gin.H {
+	return gin.H{
+		"id":                 u.ID,
+		"name":               u.FirstName + " " + u.LastName,
+		"role":               u.Role,
+		"username":           u.Username,
+		"email":              u.Email,
+		"firstName":          u.FirstName,
+		"middleName":         u.MiddleName,
+		"lastName":           u.LastName,
+		"phone":              u.Phone,
+		"deptId":             u.DeptID,
+		"deptName":           u.DeptName,
+		"authSource":         u.AuthSource,
+		"active":             u.DeactivatedAt == nil,
+		"locked":             u.LockedUntil != nil,
+		"mfaEnabled":         u.TOTPEnabled,
+		"mustChangePassword": u.MustChangePassword,
+		"registrationStatus": u.RegistrationStatus,
+	}
+}
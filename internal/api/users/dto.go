@@ -0,0 +1,30 @@
+package users
+
+type CreateUserRequest struct {
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	FirstName  string `json:"firstName"`
+	MiddleName string `json:"middleName"`
+	LastName   string `json:"lastName"`
+	Phone      string `json:"phone"`
+	DeptID     *int64 `json:"deptId"`
+	Role       string `json:"role"`
+
+	// Invite mails a link to set the password instead of issuing a
+	// temporary one.
+	Invite            bool   `json:"invite"`
+	TemporaryPassword string `json:"temporaryPassword"`
+}
+
+type UpdateUserRequest struct {
+	Role   *string `json:"role"`
+	DeptID *int64  `json:"deptId"`
+	Phone  *string `json:"phone"`
+}
+
+// LinkExternalRequest names the identity an account is linked to. For OIDC
+// ExternalID is "<provider>:<subject>", for LDAP it is the entry DN.
+type LinkExternalRequest struct {
+	Source     string `json:"source"`
+	ExternalID string `json:"externalId"`
+}
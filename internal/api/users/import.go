@@ -0,0 +1,88 @@
+package users
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/storage"
+	"komiac-support-backend/internal/userimport"
+)
+
+const maxImportSize = 10 << 20
+
+func (h *Handlers) ImportUsers(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultPostForm("dryRun", c.DefaultQuery("dryRun", "false")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad dryRun"})
+		return
+	}
+	update, err := strconv.ParseBool(c.DefaultPostForm("update", c.DefaultQuery("update", "false")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad update"})
+		return
+	}
+	invite, err := strconv.ParseBool(c.DefaultPostForm("invite", c.DefaultQuery("invite", "false")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad invite"})
+		return
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot read file"})
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	recs, err := userimport.Parse(fh.Filename, f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	uid, _ := uidFromCtx(c)
+	rep, err := userimport.Run(ctx, h.users, recs, storage.ImportOptions{DryRun: dryRun, Update: update, ActorID: uid})
+	if err != nil {
+		log.Printf("import users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	invited := 0
+	if invite && !dryRun {
+		for _, row := range rep.Rows {
+			if row.Action != storage.ImportCreate {
+				continue
+			}
+			u, err := h.users.GetByID(ctx, row.UserID)
+			if err == nil {
+				err = h.sendInvite(ctx, u)
+			}
+			if err != nil {
+				log.Printf("invite imported user %d: %v", row.UserID, err)
+				continue
+			}
+			invited++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": rep, "invited": invited})
+}
@@ -0,0 +1,86 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/mail"
+	"komiac-support-backend/internal/storage"
+)
+
+func (h *Handlers) ApproveRegistration(c *gin.Context) {
+	h.reviewRegistration(c, true)
+}
+
+func (h *Handlers) RejectRegistration(c *gin.Context) {
+	h.reviewRegistration(c, false)
+}
+
+func (h *Handlers) reviewRegistration(c *gin.Context, approve bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	u, err := h.users.GetByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if approve {
+		err = h.users.ApproveRegistration(ctx, id)
+	} else {
+		err = h.users.RejectRegistration(ctx, id)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrRegistrationNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	msg := mail.Message{To: u.Email, Subject: "Регистрация отклонена"}
+	if approve {
+		msg.Subject = "Регистрация подтверждена"
+		msg.Body = fmt.Sprintf(
+			"Здравствуйте, %s!\n\nВаша учётная запись %s подтверждена. Войти можно по ссылке:\n%s\n",
+			u.FirstName, u.Username, strings.TrimRight(h.cfg.AppBaseURL, "/")+"/login",
+		)
+	} else {
+		msg.Body = fmt.Sprintf(
+			"Здравствуйте, %s!\n\nЗаявка на регистрацию учётной записи %s отклонена. Если это ошибка, обратитесь в службу поддержки.\n",
+			u.FirstName, u.Username,
+		)
+	}
+	if err := h.mailer.Send(ctx, msg); err != nil {
+		log.Printf("registration review mail to user %d: %v", id, err)
+	}
+
+	if !approve {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		return
+	}
+
+	u, err = h.users.GetByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": userJSON(u)})
+}
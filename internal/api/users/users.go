@@ -1,42 +1,88 @@
 package users
 
 import (
+	"database/sql"
 	"net/http"
+	"strconv"
 
+	"komiac-support-backend/internal/config"
+	"komiac-support-backend/internal/mail"
 	"komiac-support-backend/internal/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handlers struct {
-	users *storage.UsersRepo
+	cfg      config.Config
+	users    *storage.UsersRepo
+	depts    *storage.DeptsRepo
+	attempts *storage.LoginAttemptsRepo
+	tickets  *storage.TicketsRepo
+	resets   *storage.PasswordResetsRepo
+	mailer   mail.Sender
 }
 
-func New(users *storage.UsersRepo) *Handlers {
-	return &Handlers{users: users}
+func New(
+	cfg config.Config,
+	users *storage.UsersRepo,
+	depts *storage.DeptsRepo,
+	attempts *storage.LoginAttemptsRepo,
+	tickets *storage.TicketsRepo,
+	resets *storage.PasswordResetsRepo,
+	mailer mail.Sender,
+) *Handlers {
+	return &Handlers{cfg: cfg, users: users, depts: depts, attempts: attempts, tickets: tickets, resets: resets, mailer: mailer}
 }
 
-func roleFromCtx(c *gin.Context) string {
-	v, _ := c.Get("role")
-	s, _ := v.(string)
-	return s
+func (h *Handlers) ListSupportUsers(c *gin.Context) {
+	items, err := h.users.ListSupportUsers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if items == nil {
+		items = make([]storage.SupportUser, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": items})
 }
 
-func (h *Handlers) ListSupportUsers(c *gin.Context) {
-	if roleFromCtx(c) != "support" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+func (h *Handlers) Unlock(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
 		return
 	}
 
-	items, err := h.users.ListSupportUsers(c.Request.Context())
+	if err := h.users.Unlock(c.Request.Context(), id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handlers) ListLoginAttempts(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad id"})
+		return
+	}
+
+	items, err := h.attempts.ListForUser(c.Request.Context(), id, 100)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
 
 	if items == nil {
-		items = make([]storage.SupportUser, 0)
+		items = make([]storage.LoginAttempt, 0)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"users": items})
+	c.JSON(http.StatusOK, gin.H{"attempts": items})
 }
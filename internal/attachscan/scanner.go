@@ -0,0 +1,135 @@
+package attachscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"komiac-support-backend/internal/blob"
+	"komiac-support-backend/internal/clamav"
+	"komiac-support-backend/internal/mail"
+	"komiac-support-backend/internal/storage"
+)
+
+const batchSize = 20
+
+// Scanner polls for pending attachments and settles each one as clean or
+// infected. Infected blobs are moved to the quarantine store.
+type Scanner struct {
+	Tickets    *storage.TicketsRepo
+	Blobs      blob.Store
+	Quarantine blob.Store
+	Clamd      *clamav.Client
+	Mailer     mail.Sender
+	Interval   time.Duration
+	// RetryAfter is how long a claimed attachment waits before another
+	// attempt when its scan did not finish.
+	RetryAfter time.Duration
+}
+
+func (s *Scanner) Run(ctx context.Context) {
+	t := time.NewTicker(s.Interval)
+	defer t.Stop()
+
+	for {
+		s.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Scanner) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		items, err := s.Tickets.ClaimPendingScans(ctx, batchSize, s.RetryAfter)
+		if err != nil {
+			log.Printf("attachscan: claim: %v", err)
+			return
+		}
+		for _, a := range items {
+			if err := s.scan(ctx, a); err != nil {
+				log.Printf("attachscan: attachment %d: %v", a.ID, err)
+			}
+		}
+		if len(items) < batchSize {
+			return
+		}
+	}
+}
+
+func (s *Scanner) scan(ctx context.Context, a storage.Attachment) error {
+	rc, err := s.Blobs.Open(ctx, a.StorageKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return s.fail(ctx, a, "файл отсутствует в хранилище")
+	}
+	if err != nil {
+		return err
+	}
+	res, err := s.Clamd.Scan(ctx, rc)
+	_ = rc.Close()
+	if errors.Is(err, clamav.ErrSizeLimit) {
+		return s.fail(ctx, a, "файл больше допустимого для антивируса размера")
+	}
+	if err != nil {
+		return err
+	}
+
+	if !res.Infected {
+		return s.Tickets.MarkAttachmentClean(ctx, a.ID)
+	}
+	return s.quarantine(ctx, a, res.Signature)
+}
+
+// fail settles an attachment that can never be scanned; retrying it would
+// only fail again.
+func (s *Scanner) fail(ctx context.Context, a storage.Attachment, reason string) error {
+	log.Printf("attachscan: attachment %d on ticket %d cannot be scanned: %s", a.ID, a.TicketID, reason)
+	return s.Tickets.MarkAttachmentFailed(ctx, a.ID, fmt.Sprintf(
+		"Вложение «%s» не удалось проверить антивирусом: %s. Файл недоступен для скачивания.",
+		a.FileName, reason,
+	))
+}
+
+// quarantine copies the blob aside before the row is marked infected, so a
+// failure at any step leaves the attachment pending and it is retried.
+func (s *Scanner) quarantine(ctx context.Context, a storage.Attachment, signature string) error {
+	rc, err := s.Blobs.Open(ctx, a.StorageKey)
+	if err != nil && !errors.Is(err, blob.ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		err = s.Quarantine.Put(ctx, a.StorageKey, rc, a.Size, a.ContentType)
+		_ = rc.Close()
+		if err != nil {
+			return fmt.Errorf("quarantine: %w", err)
+		}
+	}
+
+	info, err := s.Tickets.MarkAttachmentInfected(ctx, a.ID, signature, fmt.Sprintf(
+		"Антивирус обнаружил %s во вложении «%s». Файл помещён в карантин и недоступен для скачивания.",
+		signature, a.FileName,
+	))
+	if err != nil {
+		return err
+	}
+	if err := s.Blobs.Delete(ctx, a.StorageKey); err != nil {
+		log.Printf("attachscan: delete quarantined %s: %v", a.StorageKey, err)
+	}
+	log.Printf("attachscan: attachment %d on ticket %d infected with %s, quarantined", a.ID, info.TicketID, signature)
+
+	if info.UploaderEmail == "" {
+		return nil
+	}
+	return s.Mailer.Send(ctx, mail.Message{
+		To:      info.UploaderEmail,
+		Subject: "Вложение заблокировано антивирусом",
+		Body: fmt.Sprintf(
+			"Файл «%s», прикреплённый к заявке %s, содержит угрозу (%s) и помещён в карантин.\nЕсли файл нужен, проверьте его и загрузите заново.\n",
+			info.FileName, info.TicketNumber, signature,
+		),
+	})
+}
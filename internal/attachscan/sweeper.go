@@ -0,0 +1,54 @@
+package attachscan
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"komiac-support-backend/internal/blob"
+	"komiac-support-backend/internal/storage"
+)
+
+// Sweeper removes blobs whose attachment rows have been deleted.
+type Sweeper struct {
+	Tickets  *storage.TicketsRepo
+	Blobs    blob.Store
+	Interval time.Duration
+}
+
+func (s *Sweeper) Run(ctx context.Context) {
+	t := time.NewTicker(s.Interval)
+	defer t.Stop()
+
+	for {
+		s.sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Sweeper) sweep(ctx context.Context) {
+	for ctx.Err() == nil {
+		keys, err := s.Tickets.PendingBlobDeletions(ctx, batchSize)
+		if err != nil {
+			log.Printf("attachscan: pending blob deletions: %v", err)
+			return
+		}
+		for _, key := range keys {
+			if err := s.Blobs.Delete(ctx, key); err != nil {
+				log.Printf("attachscan: delete blob %s: %v", key, err)
+				return
+			}
+			if err := s.Tickets.ForgetBlobDeletion(ctx, key); err != nil {
+				log.Printf("attachscan: forget blob %s: %v", key, err)
+				return
+			}
+		}
+		if len(keys) < batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const APIKeyPrefix = "kmc_"
+
+const (
+	ScopeTicketsRead  = "tickets:read"
+	ScopeTicketsWrite = "tickets:write"
+	ScopeUsersRead    = "users:read"
+)
+
+var Scopes = []string{ScopeTicketsRead, ScopeTicketsWrite, ScopeUsersRead}
+
+func ValidScope(s string) bool {
+	for _, v := range Scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKey returns a key of the form kmc_<id>_<secret>, its lookup id and
+// the hash to store. Only the hash of the full key is kept.
+func NewAPIKey() (key, id, hash string, err error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	id = hex.EncodeToString(b)
+
+	secret, _, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key = APIKeyPrefix + id + "_" + secret
+	return key, id, HashToken(key), nil
+}
+
+// ParseAPIKey extracts the lookup id from a presented key.
+func ParseAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(id) != 12 || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
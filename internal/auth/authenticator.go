@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"komiac-support-backend/internal/storage"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUnknownUser        = errors.New("unknown user")
+)
+
+type Authenticator interface {
+	Authenticate(ctx context.Context, login, password string) (*storage.User, error)
+}
+
+type PasswordAuthenticator struct {
+	Users *storage.UsersRepo
+}
+
+func (a PasswordAuthenticator) Authenticate(ctx context.Context, login, password string) (*storage.User, error) {
+	u, err := a.Users.GetByLogin(ctx, login)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownUser
+		}
+		return nil, err
+	}
+	if u.AuthSource != storage.AuthSourceLocal {
+		return nil, ErrUnknownUser
+	}
+
+	if err := CheckPassword(u.PasswordHash, password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// Chain asks each authenticator in turn until one of them knows the login.
+// Backend failures are reported only if no later authenticator claims it.
+type Chain []Authenticator
+
+func (ch Chain) Authenticate(ctx context.Context, login, password string) (*storage.User, error) {
+	var backendErr error
+	for _, a := range ch {
+		u, err := a.Authenticate(ctx, login, password)
+		switch {
+		case err == nil:
+			return u, nil
+		case errors.Is(err, ErrUnknownUser):
+			continue
+		case errors.Is(err, ErrInvalidCredentials):
+			return nil, err
+		default:
+			if backendErr == nil {
+				backendErr = err
+			}
+		}
+	}
+
+	if backendErr != nil {
+		return nil, backendErr
+	}
+	return nil, ErrUnknownUser
+}
@@ -1,7 +1,8 @@
 package auth
 
 import (
-	"time"
+	"crypto/rand"
+	"encoding/hex"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -9,39 +10,37 @@ import (
 type Role string
 
 const (
-	RoleUser    Role = "user"
-	RoleSupport Role = "support"
+	RoleUser     Role = "user"
+	RoleSupport  Role = "support"
+	RoleDeptLead Role = "dept_lead"
+	RoleAdmin    Role = "admin"
+)
+
+const (
+	PurposeMFA       = "mfa"
+	PurposeMFAEnroll = "mfa_enroll"
+	PurposeRefresh   = "refresh"
 )
 
 type Claims struct {
-	UID  int64 `json:"uid"`
-	Role Role  `json:"role"`
+	UID     int64  `json:"uid"`
+	Role    Role   `json:"role"`
+	SID     string `json:"sid,omitempty"`
+	Purpose string `json:"pur,omitempty"`
+	// PasswordChange marks sessions that may only change the password.
+	PasswordChange bool `json:"pwc,omitempty"`
+	// ActorUID is the admin acting as UID; Impersonation references the
+	// audited impersonation session.
+	ActorUID      int64 `json:"act,omitempty"`
+	Impersonation int64 `json:"imp,omitempty"`
+	ReadOnly      bool  `json:"ro,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func Sign(uid int64, role Role, secret string, ttl time.Duration) (string, error) {
-	now := time.Now()
-	claims := Claims{
-		UID:  uid,
-		Role: role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
-		},
-	}
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
-}
-
-func Parse(tokenStr, secret string) (*Claims, error) {
-	t, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (any, error) {
-		return []byte(secret), nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	claims, ok := t.Claims.(*Claims)
-	if !ok || !t.Valid {
-		return nil, jwt.ErrTokenInvalidClaims
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return claims, nil
+	return hex.EncodeToString(b), nil
 }
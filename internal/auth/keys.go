@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type signingKey struct {
+	kid     string
+	alg     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// KeySet signs tokens with its active key and verifies tokens signed by any
+// key it holds, so a new key can be published before it is switched to and
+// an old one kept until the tokens it signed have expired.
+type KeySet struct {
+	issuer   string
+	audience string
+	keys     map[string]*signingKey
+	active   *signingKey
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeySet reads every <kid>.pem PKCS#8 private key in dir. activeKID picks
+// the signing key; when empty the last kid in lexical order is used, which is
+// the newest one for kids made by GenerateKey.
+func LoadKeySet(dir, activeKID, issuer, audience string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{issuer: issuer, audience: audience, keys: map[string]*signingKey{}}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		k, err := parseSigningKey(strings.TrimSuffix(filepath.Base(p), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		ks.keys[k.kid] = k
+		ks.active = k
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("no signing keys in %s", dir)
+	}
+	if activeKID != "" {
+		k, ok := ks.keys[activeKID]
+		if !ok {
+			return nil, fmt.Errorf("active key %q not found in %s", activeKID, dir)
+		}
+		ks.active = k
+	}
+	return ks, nil
+}
+
+// GenerateKey creates a key for alg and returns its kid and PEM encoding.
+func GenerateKey(alg string) (string, []byte, error) {
+	var (
+		priv any
+		err  error
+	)
+	switch alg {
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return "", nil, fmt.Errorf("unsupported key algorithm %q", alg)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", nil, err
+	}
+	kid := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+
+	return kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseSigningKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("expected a PKCS#8 PRIVATE KEY block")
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := priv.(type) {
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, alg: AlgEdDSA, method: jwt.SigningMethodEdDSA, private: k}, nil
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("rsa keys must be at least 2048 bits")
+		}
+		return &signingKey{kid: kid, alg: AlgRS256, method: jwt.SigningMethodRS256, private: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", priv)
+	}
+}
+
+func (ks *KeySet) ActiveKID() string {
+	return ks.active.kid
+}
+
+// Sign issues a token for this service's audience. Tokens with a Purpose
+// get an audience of their own so that nothing checking only iss, aud and
+// exp can take an MFA challenge or a refresh token for an access token.
+func (ks *KeySet) Sign(claims Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.Issuer = ks.issuer
+	claims.Audience = jwt.ClaimStrings{ks.purposeAudience(claims.Purpose)}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return ks.sign(claims)
+}
+
+// Parse verifies an access token.
+func (ks *KeySet) Parse(tokenStr string) (*Claims, error) {
+	return ks.ParsePurpose(tokenStr, "")
+}
+
+// ParsePurpose verifies a token signed for purpose, such as PurposeRefresh.
+func (ks *KeySet) ParsePurpose(tokenStr, purpose string) (*Claims, error) {
+	claims := &Claims{}
+	if err := ks.parse(tokenStr, claims, ks.purposeAudience(purpose)); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+func (ks *KeySet) purposeAudience(purpose string) string {
+	if purpose == "" {
+		return ks.audience
+	}
+	return ks.audience + ":" + purpose
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(ks.active.method, claims)
+	t.Header["kid"] = ks.active.kid
+	return t.SignedString(ks.active.private)
+}
+
+func (ks *KeySet) parse(tokenStr string, claims jwt.Claims, audience string) error {
+	t, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, ok := ks.keys[kid]
+		if !ok || t.Method.Alg() != k.alg {
+			return nil, ErrUnknownKey
+		}
+		return k.private.Public(), nil
+	},
+		jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}),
+		jwt.WithIssuer(ks.issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return err
+	}
+	if !t.Valid {
+		return jwt.ErrTokenInvalidClaims
+	}
+	return nil
+}
+
+func (ks *KeySet) JWKS() JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	out := JWKS{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		k := ks.keys[kid]
+		jwk := JWK{Use: "sig", Alg: k.alg, Kid: k.kid}
+
+		switch pub := k.private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		}
+		out.Keys = append(out.Keys, jwk)
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestKeySet(t *testing.T) *KeySet {
+	t.Helper()
+
+	kid, pemBytes, err := GenerateKey(AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pemBytes, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := LoadKeySet(dir, "", "komiac", "komiac-support-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestSignPurposeAudience(t *testing.T) {
+	ks := newTestKeySet(t)
+
+	purposes := []string{"", PurposeMFA, PurposeMFAEnroll, PurposeRefresh}
+	tokens := map[string]string{}
+	for _, p := range purposes {
+		tok, err := ks.Sign(Claims{UID: 7, Role: RoleUser, Purpose: p}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[p] = tok
+
+		// A consumer checking only the registered claims must not accept a
+		// purpose token as an access token.
+		_, err = jwt.Parse(tok, func(*jwt.Token) (any, error) { return ks.active.private.Public(), nil },
+			jwt.WithIssuer("komiac"), jwt.WithAudience("komiac-support-api"))
+		if accepted := err == nil; accepted != (p == "") {
+			t.Errorf("purpose %q: accepted for the access audience = %v", p, accepted)
+		}
+	}
+
+	for _, signed := range purposes {
+		for _, want := range purposes {
+			_, err := ks.ParsePurpose(tokens[signed], want)
+			if ok := err == nil; ok != (signed == want) {
+				t.Errorf("ParsePurpose(%q token, %q) err = %v", signed, want, err)
+			}
+		}
+	}
+}
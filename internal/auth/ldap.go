@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"komiac-support-backend/internal/storage"
+)
+
+const AuthSourceLDAP = "ldap"
+
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string
+
+	AttrUsername   string
+	AttrEmail      string
+	AttrFirstName  string
+	AttrMiddleName string
+	AttrLastName   string
+	AttrPhone      string
+	AttrDept       string
+	AttrGroups     string
+
+	GroupRoles  []RoleMapping
+	DefaultRole string
+}
+
+type LDAPConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+type ExternalUserStore interface {
+	UpsertExternal(ctx context.Context, p storage.ExternalUserParams) (*storage.User, error)
+}
+
+type LDAPAuthenticator struct {
+	Cfg   LDAPConfig
+	Users ExternalUserStore
+	Dial  func(ctx context.Context) (LDAPConn, error)
+}
+
+func NewLDAPAuthenticator(cfg LDAPConfig, users ExternalUserStore) *LDAPAuthenticator {
+	a := &LDAPAuthenticator{Cfg: cfg, Users: users}
+	a.Dial = a.dial
+	return a
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, login, password string) (*storage.User, error) {
+	login = strings.TrimSpace(login)
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.Dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if a.Cfg.BindDN != "" {
+		if err := conn.Bind(a.Cfg.BindDN, a.Cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	entry, err := a.findUser(conn, login)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+
+	p := storage.ExternalUserParams{
+		Source:     AuthSourceLDAP,
+		ExternalID: entry.DN,
+		Username:   a.attr(entry, a.Cfg.AttrUsername),
+		Email:      a.attr(entry, a.Cfg.AttrEmail),
+		FirstName:  a.attr(entry, a.Cfg.AttrFirstName),
+		MiddleName: a.attr(entry, a.Cfg.AttrMiddleName),
+		LastName:   a.attr(entry, a.Cfg.AttrLastName),
+		Phone:      a.attr(entry, a.Cfg.AttrPhone),
+		DeptName:   a.attr(entry, a.Cfg.AttrDept),
+		Role:       a.roleFor(entry.GetAttributeValues(a.Cfg.AttrGroups)),
+	}
+	if p.Username == "" {
+		p.Username = login
+	}
+	if p.Email == "" || p.FirstName == "" || p.LastName == "" {
+		return nil, fmt.Errorf("ldap entry %s lacks email or name attributes", entry.DN)
+	}
+
+	return a.Users.UpsertExternal(ctx, p)
+}
+
+func (a *LDAPAuthenticator) findUser(conn LDAPConn, login string) (*ldap.Entry, error) {
+	attrs := []string{"dn"}
+	for _, at := range []string{
+		a.Cfg.AttrUsername, a.Cfg.AttrEmail, a.Cfg.AttrFirstName, a.Cfg.AttrMiddleName,
+		a.Cfg.AttrLastName, a.Cfg.AttrPhone, a.Cfg.AttrDept, a.Cfg.AttrGroups,
+	} {
+		if at != "" {
+			attrs = append(attrs, at)
+		}
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.Cfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(a.Cfg.Timeout.Seconds()),
+		false,
+		strings.ReplaceAll(a.Cfg.UserFilter, "{login}", ldap.EscapeFilter(login)),
+		attrs,
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrUnknownUser
+		}
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return nil, ErrUnknownUser
+	case 1:
+		return res.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("ldap search: login %q matches several entries", login)
+	}
+}
+
+func (a *LDAPAuthenticator) attr(e *ldap.Entry, name string) string {
+	if name == "" {
+		return ""
+	}
+	return strings.TrimSpace(e.GetAttributeValue(name))
+}
+
+func (a *LDAPAuthenticator) roleFor(groups []string) string {
+	return mapRole(a.Cfg.GroupRoles, groups, func(g, want string) bool {
+		return strings.EqualFold(g, want) || strings.EqualFold(groupCN(g), want)
+	}, a.Cfg.DefaultRole)
+}
+
+func groupCN(dn string) string {
+	first, _, _ := strings.Cut(dn, ",")
+	k, v, ok := strings.Cut(first, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(k), "cn") {
+		return ""
+	}
+	return strings.TrimSpace(v)
+}
+
+func (a *LDAPAuthenticator) dial(_ context.Context) (LDAPConn, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: a.Cfg.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: a.Cfg.Timeout}
+
+	conn, err := ldap.DialURL(a.Cfg.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsCfg))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.Cfg.Timeout)
+
+	if a.Cfg.StartTLS {
+		if err := conn.StartTLS(tlsCfg); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"komiac-support-backend/internal/storage"
+)
+
+const testServiceDN = "cn=svc,dc=corp,dc=local"
+
+// fakeDirectory is an in-process LDAP server: it answers binds from a
+// password table and searches by matching the login in the filter.
+type fakeDirectory struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+	bound     string
+}
+
+func (d *fakeDirectory) Bind(dn, password string) error {
+	if want, ok := d.passwords[dn]; !ok || want != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	d.bound = dn
+	return nil
+}
+
+func (d *fakeDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if d.bound != testServiceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("not bound"))
+	}
+	res := &ldap.SearchResult{}
+	for _, e := range d.entries {
+		if strings.Contains(req.Filter, "(uid="+e.GetAttributeValue("uid")+")") {
+			res.Entries = append(res.Entries, e)
+		}
+	}
+	return res, nil
+}
+
+func (d *fakeDirectory) Close() error { return nil }
+
+type fakeExternalUsers struct {
+	got []storage.ExternalUserParams
+	err error
+}
+
+func (s *fakeExternalUsers) UpsertExternal(_ context.Context, p storage.ExternalUserParams) (*storage.User, error) {
+	s.got = append(s.got, p)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &storage.User{Username: p.Username, Email: p.Email, Role: p.Role, AuthSource: p.Source}, nil
+}
+
+func newTestLDAP(t *testing.T, users *fakeExternalUsers) *LDAPAuthenticator {
+	t.Helper()
+
+	roles, err := ParseRoleMappings("support=CN=Helpdesk,OU=Groups,DC=corp,DC=local;admin=Domain Admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := &fakeDirectory{
+		passwords: map[string]string{
+			testServiceDN:                           "svc-secret",
+			"uid=ivanov,ou=people,dc=corp,dc=local": "ivanov-pass",
+			"uid=petrov,ou=people,dc=corp,dc=local": "petrov-pass",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=ivanov,ou=people,dc=corp,dc=local", map[string][]string{
+				"uid":       {"ivanov"},
+				"mail":      {"ivanov@corp.local"},
+				"givenName": {"Иван"},
+				"sn":        {"Иванов"},
+				"memberOf":  {"CN=Helpdesk,OU=Groups,DC=corp,DC=local"},
+			}),
+			ldap.NewEntry("uid=petrov,ou=people,dc=corp,dc=local", map[string][]string{
+				"uid":       {"petrov"},
+				"mail":      {"petrov@corp.local"},
+				"givenName": {"Пётр"},
+				"sn":        {"Петров"},
+				"memberOf":  {"CN=Accounting,OU=Groups,DC=corp,DC=local"},
+			}),
+		},
+	}
+
+	a := NewLDAPAuthenticator(LDAPConfig{
+		BindDN:        testServiceDN,
+		BindPassword:  "svc-secret",
+		BaseDN:        "dc=corp,dc=local",
+		UserFilter:    "(&(objectClass=person)(uid={login}))",
+		AttrUsername:  "uid",
+		AttrEmail:     "mail",
+		AttrFirstName: "givenName",
+		AttrLastName:  "sn",
+		AttrGroups:    "memberOf",
+		GroupRoles:    roles,
+		DefaultRole:   string(RoleUser),
+	}, users)
+	a.Dial = func(context.Context) (LDAPConn, error) {
+		dir.bound = ""
+		return dir, nil
+	}
+	return a
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	users := &fakeExternalUsers{}
+	a := newTestLDAP(t, users)
+
+	u, err := a.Authenticate(context.Background(), "ivanov", "ivanov-pass")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if u.Role != string(RoleSupport) {
+		t.Errorf("role = %q, want %q", u.Role, RoleSupport)
+	}
+	p := users.got[0]
+	if p.Source != AuthSourceLDAP || p.ExternalID != "uid=ivanov,ou=people,dc=corp,dc=local" {
+		t.Errorf("identity = %s/%s", p.Source, p.ExternalID)
+	}
+	if p.Email != "ivanov@corp.local" || p.FirstName != "Иван" || p.LastName != "Иванов" {
+		t.Errorf("attributes = %+v", p)
+	}
+
+	u, err = a.Authenticate(context.Background(), "petrov", "petrov-pass")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if u.Role != string(RoleUser) {
+		t.Errorf("unmapped group role = %q, want %q", u.Role, RoleUser)
+	}
+}
+
+func TestLDAPAuthenticateRejects(t *testing.T) {
+	users := &fakeExternalUsers{}
+	a := newTestLDAP(t, users)
+
+	tests := []struct {
+		login, password string
+		want            error
+	}{
+		{"ivanov", "wrong", ErrInvalidCredentials},
+		{"ivanov", "", ErrInvalidCredentials},
+		{"sidorov", "whatever", ErrUnknownUser},
+		{"*", "whatever", ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		if _, err := a.Authenticate(context.Background(), tt.login, tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("Authenticate(%q, %q) = %v, want %v", tt.login, tt.password, err, tt.want)
+		}
+	}
+	if len(users.got) != 0 {
+		t.Errorf("provisioned %d accounts for failed logins", len(users.got))
+	}
+}
+
+func TestLDAPAuthenticateConflict(t *testing.T) {
+	a := newTestLDAP(t, &fakeExternalUsers{err: storage.ErrExternalConflict})
+
+	_, err := Chain{a}.Authenticate(context.Background(), "ivanov", "ivanov-pass")
+	if !errors.Is(err, storage.ErrExternalConflict) {
+		t.Fatalf("err = %v, want ErrExternalConflict", err)
+	}
+}
+
+func TestParseRoleMappingsRejectsUnknownRole(t *testing.T) {
+	if _, err := ParseRoleMappings("superuser=Domain Admins"); err == nil {
+		t.Fatal("expected an error for an unknown role")
+	}
+	got, err := ParseRoleMappings(" support = Helpdesk ; ;user=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != (RoleMapping{Match: "Helpdesk", Role: "support"}) {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/mail"
+	"unicode"
+)
+
+const (
+	MinPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong  = errors.New("password must be at most 72 bytes")
+	ErrPasswordTooWeak  = errors.New("password must contain both letters and digits")
+)
+
+func ValidatePassword(password string) error {
+	if len([]rune(password)) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > maxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return ErrPasswordTooWeak
+	}
+	return nil
+}
+
+// NewTemporaryPassword returns a random password that satisfies
+// ValidatePassword, for accounts created on someone's behalf.
+func NewTemporaryPassword() (string, error) {
+	const (
+		letters = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+		digits  = "23456789"
+		length  = 12
+	)
+
+	for {
+		b := make([]byte, length)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		alphabet := letters + digits
+		for i := range b {
+			b[i] = alphabet[int(b[i])%len(alphabet)]
+		}
+		if p := string(b); ValidatePassword(p) == nil {
+			return p, nil
+		}
+	}
+}
+
+// ValidEmail accepts a bare address that fits the users.email column.
+func ValidEmail(s string) bool {
+	if s == "" || len(s) > 100 {
+		return false
+	}
+	a, err := mail.ParseAddress(s)
+	return err == nil && a.Address == s
+}
@@ -0,0 +1,84 @@
+package auth
+
+import "slices"
+
+type Permission string
+
+const (
+	PermTicketCreate    Permission = "ticket.create"
+	PermTicketViewAll   Permission = "ticket.view_all"
+	PermTicketViewDept  Permission = "ticket.view_dept"
+	PermTicketAssign    Permission = "ticket.assign"
+	PermTicketReply     Permission = "ticket.reply"
+	PermTicketClose     Permission = "ticket.close"
+	PermUserView        Permission = "user.view"
+	PermUserUnlock      Permission = "user.unlock"
+	PermUserManage      Permission = "user.manage"
+	PermUserImpersonate Permission = "user.impersonate"
+	PermDeptManage      Permission = "dept.manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser: {
+		PermTicketCreate,
+	},
+	RoleDeptLead: {
+		PermTicketCreate,
+		PermTicketViewDept,
+	},
+	RoleSupport: {
+		PermTicketViewAll,
+		PermTicketAssign,
+		PermTicketReply,
+		PermTicketClose,
+		PermUserView,
+		PermUserUnlock,
+	},
+	RoleAdmin: {
+		PermTicketCreate,
+		PermTicketViewAll,
+		PermTicketAssign,
+		PermTicketReply,
+		PermTicketClose,
+		PermUserView,
+		PermUserUnlock,
+		PermUserManage,
+		PermUserImpersonate,
+		PermDeptManage,
+	},
+}
+
+var Roles = []Role{RoleUser, RoleDeptLead, RoleSupport, RoleAdmin}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[Role(role)]
+	return ok
+}
+
+func (r Role) Can(p Permission) bool {
+	return slices.Contains(rolePermissions[r], p)
+}
+
+func (r Role) CanAny(ps ...Permission) bool {
+	for _, p := range ps {
+		if r.Can(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Role) Permissions() []Permission {
+	return slices.Clone(rolePermissions[r])
+}
+
+// RolesWith lists the roles granted p.
+func RolesWith(p Permission) []Role {
+	var out []Role
+	for _, r := range Roles {
+		if r.Can(p) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
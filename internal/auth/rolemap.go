@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoleMapping grants Role when an external attribute (an LDAP group, an OIDC
+// claim value) equals Match.
+type RoleMapping struct {
+	Match string
+	Role  string
+}
+
+// ParseRoleMappings reads "role=value;role=value" pairs, e.g.
+// "support=CN=Helpdesk,OU=Groups,DC=corp,DC=local;user=Staff".
+func ParseRoleMappings(s string) ([]RoleMapping, error) {
+	var out []RoleMapping
+	for _, pair := range strings.Split(s, ";") {
+		role, match, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || strings.TrimSpace(role) == "" || strings.TrimSpace(match) == "" {
+			continue
+		}
+		role = strings.TrimSpace(role)
+		if !ValidRole(role) {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+		out = append(out, RoleMapping{Match: strings.TrimSpace(match), Role: role})
+	}
+	return out, nil
+}
+
+func mapRole(mappings []RoleMapping, values []string, matches func(value, want string) bool, def string) string {
+	for _, m := range mappings {
+		for _, v := range values {
+			if matches(v, m.Match) {
+				return m.Role
+			}
+		}
+	}
+	if def != "" {
+		return def
+	}
+	return string(RoleUser)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 SHA-1 test key "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestVerifyTOTP(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	step := at.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		code     string
+		t        time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"rfc vector", "081804", at, step, true},
+		{"spaces", " 081 804 ", at, step, true},
+		{"previous step", "081804", at.Add(totpPeriod * time.Second), step, true},
+		{"next step", "081804", at.Add(-totpPeriod * time.Second), step, true},
+		{"beyond skew", "081804", at.Add(2 * totpPeriod * time.Second), 0, false},
+		{"beyond skew before", "081804", at.Add(-2 * totpPeriod * time.Second), 0, false},
+		{"wrong code", "081805", at, 0, false},
+		{"too short", "81804", at, 0, false},
+		{"eight digits", "07081804", at, 0, false},
+		{"empty", "", at, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := VerifyTOTP(rfcSecret, tt.code, tt.t)
+		if ok != tt.wantOK || got != tt.wantStep {
+			t.Errorf("%s: VerifyTOTP(%q) = %d, %v; want %d, %v", tt.name, tt.code, got, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+
+	if _, ok := VerifyTOTP("not base32!", "081804", at); ok {
+		t.Error("accepted a code for a malformed secret")
+	}
+}
+
+// Replays are refused by the caller remembering the last used step, so the
+// same code must map to the same step anywhere inside the skew window.
+func TestVerifyTOTPReplayStep(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, ok := VerifyTOTP(rfcSecret, code, now)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+	again, ok := VerifyTOTP(rfcSecret, code, now.Add(totpPeriod*time.Second))
+	if !ok || again != first {
+		t.Errorf("replayed code step = %d, %v; want %d", again, ok, first)
+	}
+
+	next, err := TOTPCode(rfcSecret, now.Add(totpPeriod*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := VerifyTOTP(rfcSecret, next, now.Add(totpPeriod*time.Second)); !ok || step <= first {
+		t.Errorf("next code step = %d, %v; want > %d", step, ok, first)
+	}
+}
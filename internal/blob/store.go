@@ -0,0 +1,63 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps attachment contents; metadata lives in the database.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type Config struct {
+	Driver      string
+	Dir         string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+
+	QuarantineDir      string
+	S3QuarantineBucket string
+}
+
+func New(ctx context.Context, cfg Config) (Store, error) {
+	switch cfg.Driver {
+	case "", "local":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("blob: BLOB_DIR is required for the local driver")
+		}
+		return NewLocal(cfg.Dir)
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, fmt.Errorf("blob: S3_ENDPOINT and S3_BUCKET are required for the s3 driver")
+		}
+		return NewS3(ctx, S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			UseSSL:    cfg.S3UseSSL,
+		})
+	default:
+		return nil, fmt.Errorf("blob: unknown driver %q", cfg.Driver)
+	}
+}
+
+// NewQuarantine opens the store infected files are moved to: a separate
+// directory or bucket on the same driver.
+func NewQuarantine(ctx context.Context, cfg Config) (Store, error) {
+	cfg.Dir = cfg.QuarantineDir
+	cfg.S3Bucket = cfg.S3QuarantineBucket
+	return New(ctx, cfg)
+}
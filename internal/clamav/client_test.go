@@ -0,0 +1,195 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks enough of the clamd protocol for Client: zPING and
+// zINSTREAM with length-prefixed chunks. It records the chunk sizes it saw.
+type fakeClamd struct {
+	t         *testing.T
+	ln        net.Listener
+	maxStream int
+	chunks    chan []int
+}
+
+func startFakeClamd(t *testing.T, maxStream int) *fakeClamd {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeClamd{t: t, ln: ln, maxStream: maxStream, chunks: make(chan []int, 16)}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeClamd) client() *Client {
+	return &Client{Address: "tcp://" + f.ln.Addr().String(), Timeout: 5 * time.Second}
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch cmd {
+	case "zPING\x00":
+		_, _ = conn.Write([]byte("PONG\x00"))
+		return
+	case "zINSTREAM\x00":
+	default:
+		_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var (
+		data    bytes.Buffer
+		sizes   []int
+		replied bool
+	)
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		n := int(binary.BigEndian.Uint32(hdr[:]))
+		if n == 0 {
+			break
+		}
+		sizes = append(sizes, n)
+		if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+			return
+		}
+		if !replied && data.Len() > f.maxStream {
+			// clamd answers straight away; keep draining so the reply is
+			// not lost to a reset.
+			_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			replied = true
+		}
+	}
+	f.chunks <- sizes
+	if replied {
+		return
+	}
+
+	reply := "stream: OK\x00"
+	switch {
+	case strings.Contains(data.String(), eicar):
+		reply = "stream: Win.Test.EICAR_HDB-1 FOUND\x00"
+	case strings.Contains(data.String(), "broken"):
+		reply = "stream: Can't allocate memory ERROR\x00"
+	}
+	_, _ = conn.Write([]byte(reply))
+}
+
+func TestPing(t *testing.T) {
+	f := startFakeClamd(t, 1<<20)
+	if err := f.client().Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestScanChunkFraming(t *testing.T) {
+	f := startFakeClamd(t, 1<<20)
+
+	body := bytes.Repeat([]byte("a"), 2*chunkSize+123)
+	res, err := f.client().Scan(context.Background(), bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if res.Infected {
+		t.Fatalf("clean stream reported infected: %+v", res)
+	}
+
+	sizes := <-f.chunks
+	want := []int{chunkSize, chunkSize, 123}
+	if len(sizes) != len(want) {
+		t.Fatalf("chunks = %v, want %v", sizes, want)
+	}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Fatalf("chunks = %v, want %v", sizes, want)
+		}
+	}
+}
+
+func TestScanEmpty(t *testing.T) {
+	f := startFakeClamd(t, 1<<20)
+
+	if _, err := f.client().Scan(context.Background(), strings.NewReader("")); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if sizes := <-f.chunks; len(sizes) != 0 {
+		t.Fatalf("empty stream sent chunks %v", sizes)
+	}
+}
+
+func TestScanReplies(t *testing.T) {
+	f := startFakeClamd(t, 3*chunkSize)
+
+	res, err := f.client().Scan(context.Background(), strings.NewReader("prefix "+eicar))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !res.Infected || res.Signature != "Win.Test.EICAR_HDB-1" {
+		t.Errorf("infected stream = %+v", res)
+	}
+
+	_, err = f.client().Scan(context.Background(), bytes.NewReader(make([]byte, 4*chunkSize)))
+	if !errors.Is(err, ErrSizeLimit) {
+		t.Errorf("oversized stream err = %v, want ErrSizeLimit", err)
+	}
+
+	_, err = f.client().Scan(context.Background(), strings.NewReader("broken"))
+	if err == nil || errors.Is(err, ErrSizeLimit) || !strings.Contains(err.Error(), "Can't allocate memory") {
+		t.Errorf("error reply err = %v", err)
+	}
+}
+
+func TestParseScanReply(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  Result
+		err   error
+	}{
+		{"stream: OK", Result{}, nil},
+		{"stream: Eicar-Signature FOUND", Result{Infected: true, Signature: "Eicar-Signature"}, nil},
+		{"INSTREAM size limit exceeded. ERROR", Result{}, ErrSizeLimit},
+	}
+	for _, tt := range tests {
+		got, err := parseScanReply(tt.reply)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("parseScanReply(%q) = %+v, %v; want %+v, %v", tt.reply, got, err, tt.want, tt.err)
+		}
+	}
+
+	if _, err := parseScanReply("stream: lstat() failed ERROR"); err == nil {
+		t.Error("expected an error for an ERROR reply")
+	}
+}
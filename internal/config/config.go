@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -19,17 +20,147 @@ type SeedAccount struct {
 	Dept     string
 }
 
+type MailConfig struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUser     string
+	SMTPPassword string
+	Dir          string
+}
+
+type BlobConfig struct {
+	Driver      string
+	Dir         string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+
+	QuarantineDir      string
+	S3QuarantineBucket string
+}
+
+type AttachmentConfig struct {
+	MaxSize     int64
+	TicketQuota int64
+	// AllowedTypes are matched against the sniffed type, not the one the
+	// client sends.
+	AllowedTypes []string
+}
+
+type ClamAVConfig struct {
+	// Address of clamd; empty switches scanning off and uploads are
+	// treated as clean.
+	Address      string
+	Timeout      time.Duration
+	ScanInterval time.Duration
+}
+
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string
+
+	AttrUsername   string
+	AttrEmail      string
+	AttrFirstName  string
+	AttrMiddleName string
+	AttrLastName   string
+	AttrPhone      string
+	AttrDept       string
+	AttrGroups     string
+
+	GroupRoles  string
+	DefaultRole string
+}
+
+type OIDCConfig struct {
+	Provider     string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	UsernameClaim string
+	RoleClaim     string
+	RoleMap       string
+	DefaultRole   string
+	DeptClaim     string
+}
+
+type RegistrationConfig struct {
+	Enabled         bool
+	RequireApproval bool
+	// EmailDomains limits sign-up to these address domains; empty allows any.
+	EmailDomains []string
+	PerIP        int
+}
+
 type Config struct {
 	DatabaseURL string
+	DBMigrate   string
 
 	CorsOrigin   string
 	CookieSecure bool
 	CookieDomain string
 
-	AccessSecret  string
-	RefreshSecret string
-	AccessTTL     time.Duration
-	RefreshTTL    time.Duration
+	JWTKeysDir   string
+	JWTActiveKID string
+	JWTKeyAlg    string
+	JWTIssuer    string
+	JWTAudience  string
+	AccessTTL    time.Duration
+	RefreshTTL   time.Duration
+
+	AppBaseURL string
+	Mail       MailConfig
+
+	PasswordResetTTL        time.Duration
+	PasswordResetPerAccount int
+	PasswordResetPerIP      int
+
+	EmailVerificationTTL time.Duration
+	UserInviteTTL        time.Duration
+
+	Registration RegistrationConfig
+
+	ImpersonationTTL time.Duration
+
+	TicketReopenWindow time.Duration
+
+	TicketNumberTemplate string
+	TicketNumberPrefix   string
+	// TicketSLA is the resolution deadline per priority.
+	TicketSLA map[string]time.Duration
+
+	Blob        BlobConfig
+	Attachments AttachmentConfig
+	ClamAV      ClamAVConfig
+
+	AuthBackends []string
+	LDAP         LDAPConfig
+	OIDC         OIDCConfig
+
+	LoginMaxFailures   int
+	LoginLockout       time.Duration
+	LoginIPMaxFailures int
+	LoginFailureWindow time.Duration
+	LoginMaxDelay      time.Duration
+
+	MFARequiredRoles []string
+	MFAChallengeTTL  time.Duration
+	TOTPIssuer       string
 
 	SeedAdmin SeedAccount
 	SeedUser  SeedAccount
@@ -41,17 +172,127 @@ func Load() Config {
 
 	return Config{
 		DatabaseURL: mustEnv("DATABASE_URL"),
+		DBMigrate:   env("DB_MIGRATE", "auto"),
 
 		CorsOrigin:   env("CORS_ORIGIN", ""),
 		CookieDomain: env("COOKIE_DOMAIN", ""),
 		CookieSecure: envBool("COOKIE_SECURE", false),
 
-		AccessSecret:  mustEnv("JWT_ACCESS_SECRET"),
-		RefreshSecret: mustEnv("JWT_REFRESH_SECRET"),
+		JWTKeysDir:   env("JWT_KEYS_DIR", "keys"),
+		JWTActiveKID: env("JWT_ACTIVE_KID", ""),
+		JWTKeyAlg:    env("JWT_KEY_ALG", "EdDSA"),
+		JWTIssuer:    env("JWT_ISSUER", "komiac-support"),
+		JWTAudience:  env("JWT_AUDIENCE", "komiac-support-api"),
 
 		AccessTTL:  envDurationMinutes("JWT_ACCESS_TTL_MIN", 15),
 		RefreshTTL: envDurationDays("JWT_REFRESH_TTL_DAYS", 30),
 
+		AppBaseURL: env("APP_BASE_URL", env("CORS_ORIGIN", "")),
+		Mail: MailConfig{
+			Driver:       env("MAIL_DRIVER", "log"),
+			From:         env("MAIL_FROM", ""),
+			SMTPHost:     env("SMTP_HOST", ""),
+			SMTPPort:     envInt("SMTP_PORT", 587),
+			SMTPUser:     env("SMTP_USER", ""),
+			SMTPPassword: env("SMTP_PASSWORD", ""),
+			Dir:          env("MAIL_DIR", ""),
+		},
+
+		PasswordResetTTL:        envDurationMinutes("PASSWORD_RESET_TTL_MIN", 30),
+		PasswordResetPerAccount: envInt("PASSWORD_RESET_PER_ACCOUNT_HOUR", 3),
+		PasswordResetPerIP:      envInt("PASSWORD_RESET_PER_IP_HOUR", 10),
+
+		EmailVerificationTTL: envDurationMinutes("EMAIL_VERIFICATION_TTL_MIN", 24*60),
+		UserInviteTTL:        envDurationDays("USER_INVITE_TTL_DAYS", 7),
+
+		Registration: RegistrationConfig{
+			Enabled:         envBool("REGISTRATION_ENABLED", false),
+			RequireApproval: envBool("REGISTRATION_REQUIRE_APPROVAL", true),
+			EmailDomains:    envList("REGISTRATION_EMAIL_DOMAINS"),
+			PerIP:           envInt("REGISTRATION_PER_IP_HOUR", 5),
+		},
+
+		ImpersonationTTL: envDurationMinutes("IMPERSONATION_TTL_MIN", 15),
+
+		TicketReopenWindow: envDurationDays("TICKET_REOPEN_WINDOW_DAYS", 7),
+
+		TicketNumberTemplate: env("TICKET_NUMBER_TEMPLATE", "{seq:06}"),
+		TicketNumberPrefix:   env("TICKET_NUMBER_PREFIX", ""),
+		TicketSLA:            envHoursByKey("TICKET_SLA_HOURS", "high=8,medium=24,low=72"),
+
+		Blob: BlobConfig{
+			Driver:      env("BLOB_DRIVER", "local"),
+			Dir:         env("BLOB_DIR", "data/attachments"),
+			S3Endpoint:  env("S3_ENDPOINT", ""),
+			S3Region:    env("S3_REGION", ""),
+			S3Bucket:    env("S3_BUCKET", ""),
+			S3AccessKey: env("S3_ACCESS_KEY", ""),
+			S3SecretKey: env("S3_SECRET_KEY", ""),
+			S3UseSSL:    envBool("S3_USE_SSL", true),
+
+			QuarantineDir:      env("BLOB_QUARANTINE_DIR", "data/quarantine"),
+			S3QuarantineBucket: env("S3_QUARANTINE_BUCKET", ""),
+		},
+		Attachments: AttachmentConfig{
+			MaxSize:      int64(envInt("ATTACHMENT_MAX_MB", 10)) << 20,
+			TicketQuota:  int64(envInt("ATTACHMENT_TICKET_QUOTA_MB", 50)) << 20,
+			AllowedTypes: envListDefault("ATTACHMENT_ALLOWED_TYPES", "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain,application/zip"),
+		},
+		ClamAV: ClamAVConfig{
+			Address:      env("CLAMD_ADDRESS", ""),
+			Timeout:      time.Duration(envInt("CLAMD_TIMEOUT_SEC", 60)) * time.Second,
+			ScanInterval: time.Duration(envInt("ATTACHMENT_SCAN_INTERVAL_SEC", 5)) * time.Second,
+		},
+
+		AuthBackends: envListDefault("AUTH_BACKENDS", "password"),
+		LDAP: LDAPConfig{
+			URL:                env("LDAP_URL", ""),
+			StartTLS:           envBool("LDAP_START_TLS", false),
+			InsecureSkipVerify: envBool("LDAP_INSECURE_SKIP_VERIFY", false),
+			Timeout:            time.Duration(envInt("LDAP_TIMEOUT_SEC", 5)) * time.Second,
+
+			BindDN:       env("LDAP_BIND_DN", ""),
+			BindPassword: env("LDAP_BIND_PASSWORD", ""),
+			BaseDN:       env("LDAP_BASE_DN", ""),
+			UserFilter:   env("LDAP_USER_FILTER", "(&(objectClass=user)(sAMAccountName={login}))"),
+
+			AttrUsername:   env("LDAP_ATTR_USERNAME", "sAMAccountName"),
+			AttrEmail:      env("LDAP_ATTR_EMAIL", "mail"),
+			AttrFirstName:  env("LDAP_ATTR_FIRST_NAME", "givenName"),
+			AttrMiddleName: env("LDAP_ATTR_MIDDLE_NAME", ""),
+			AttrLastName:   env("LDAP_ATTR_LAST_NAME", "sn"),
+			AttrPhone:      env("LDAP_ATTR_PHONE", "telephoneNumber"),
+			AttrDept:       env("LDAP_ATTR_DEPT", "department"),
+			AttrGroups:     env("LDAP_ATTR_GROUPS", "memberOf"),
+
+			GroupRoles:  env("LDAP_GROUP_ROLES", ""),
+			DefaultRole: env("LDAP_DEFAULT_ROLE", "user"),
+		},
+		OIDC: OIDCConfig{
+			Provider:     env("OIDC_PROVIDER_NAME", "keycloak"),
+			IssuerURL:    env("OIDC_ISSUER_URL", ""),
+			ClientID:     env("OIDC_CLIENT_ID", ""),
+			ClientSecret: env("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  env("OIDC_REDIRECT_URL", ""),
+			Scopes:       envList("OIDC_SCOPES"),
+
+			UsernameClaim: env("OIDC_USERNAME_CLAIM", "preferred_username"),
+			RoleClaim:     env("OIDC_ROLE_CLAIM", "realm_access.roles"),
+			RoleMap:       env("OIDC_ROLE_MAP", ""),
+			DefaultRole:   env("OIDC_DEFAULT_ROLE", "user"),
+			DeptClaim:     env("OIDC_DEPT_CLAIM", ""),
+		},
+
+		LoginMaxFailures:   envInt("LOGIN_MAX_FAILURES", 5),
+		LoginLockout:       envDurationMinutes("LOGIN_LOCKOUT_MIN", 15),
+		LoginIPMaxFailures: envInt("LOGIN_IP_MAX_FAILURES", 30),
+		LoginFailureWindow: envDurationMinutes("LOGIN_FAILURE_WINDOW_MIN", 15),
+		LoginMaxDelay:      time.Duration(envInt("LOGIN_MAX_DELAY_SEC", 5)) * time.Second,
+
+		MFARequiredRoles: envList("MFA_REQUIRED_ROLES"),
+		MFAChallengeTTL:  envDurationMinutes("MFA_CHALLENGE_TTL_MIN", 5),
+		TOTPIssuer:       env("TOTP_ISSUER", "Komiac Support"),
+
 		SeedDepts: envBool("SEED_DEPTS", true),
 		SeedAdmin: SeedAccount{
 			Enabled:  envBool("SEED_ADMIN", true),
@@ -100,6 +341,54 @@ func envBool(k string, def bool) bool {
 	return b
 }
 
+func envListDefault(k, def string) []string {
+	if v, ok := os.LookupEnv(k); !ok || strings.TrimSpace(v) == "" {
+		return envSplit(def)
+	}
+	return envList(k)
+}
+
+// envHoursByKey parses "key=hours" pairs, e.g. "high=8,low=72". Malformed
+// pairs are skipped.
+func envHoursByKey(k, def string) map[string]time.Duration {
+	out := map[string]time.Duration{}
+	for _, pair := range envListDefault(k, def) {
+		key, v, ok := strings.Cut(pair, "=")
+		h, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil || h <= 0 {
+			continue
+		}
+		out[strings.TrimSpace(key)] = time.Duration(h) * time.Hour
+	}
+	return out
+}
+
+func envList(k string) []string {
+	return envSplit(os.Getenv(k))
+}
+
+func envSplit(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func envInt(k string, def int) int {
+	v, ok := os.LookupEnv(k)
+	if !ok || v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
 func envDurationMinutes(k string, defMin int) time.Duration {
 	v, ok := os.LookupEnv(k)
 	if !ok || v == "" {
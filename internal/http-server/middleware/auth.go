@@ -1,37 +1,165 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	"komiac-support-backend/internal/auth"
+	postgres "komiac-support-backend/internal/storage"
 )
 
 type AuthConfig struct {
-	AccessSecret string
+	Keys *auth.KeySet
+	// APIKeys enables API key authentication; nil accepts JWTs only.
+	APIKeys *postgres.APIKeysRepo
+	// AllowPasswordChange admits sessions that must change their password
+	// before they can do anything else.
+	AllowPasswordChange bool
+	// Impersonations enables impersonation tokens; nil rejects them.
+	Impersonations *postgres.ImpersonationsRepo
 }
 
 func RequireAuth(cfg AuthConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		h := c.GetHeader("Authorization")
-		if h == "" || !strings.HasPrefix(h, "Bearer ") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "no token"})
-			c.Abort()
+		token := c.GetHeader("X-API-Key")
+		if token == "" {
+			h := c.GetHeader("Authorization")
+			if h == "" || !strings.HasPrefix(h, "Bearer ") {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "no token"})
+				c.Abort()
+				return
+			}
+			token = strings.TrimPrefix(h, "Bearer ")
+		}
+
+		if auth.IsAPIKey(token) {
+			requireAPIKey(c, cfg.APIKeys, token)
 			return
 		}
 
-		token := strings.TrimPrefix(h, "Bearer ")
-		claims, err := auth.Parse(token, cfg.AccessSecret)
+		claims, err := cfg.Keys.Parse(token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
+		if claims.PasswordChange && !cfg.AllowPasswordChange {
+			c.JSON(http.StatusForbidden, gin.H{"error": "password change required"})
+			c.Abort()
+			return
+		}
+
+		if claims.Impersonation != 0 && !checkImpersonation(c, cfg.Impersonations, claims) {
+			return
+		}
+
 		c.Set("uid", claims.UID)
 		c.Set("role", string(claims.Role))
+		c.Set("sid", claims.SID)
+		c.Next()
+	}
+}
+
+func checkImpersonation(c *gin.Context, repo *postgres.ImpersonationsRepo, claims *auth.Claims) bool {
+	if repo == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not available while impersonating"})
+		c.Abort()
+		return false
+	}
+
+	active, err := repo.Touch(c.Request.Context(), claims.Impersonation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		c.Abort()
+		return false
+	}
+	if !active {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "impersonation ended"})
+		c.Abort()
+		return false
+	}
+
+	if claims.ReadOnly {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "impersonation is read-only"})
+			c.Abort()
+			return false
+		}
+	}
+
+	c.Set("actorUid", claims.ActorUID)
+	c.Set("impersonationId", claims.Impersonation)
+	return true
+}
+
+func requireAPIKey(c *gin.Context, repo *postgres.APIKeysRepo, key string) {
+	if repo == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "api keys are not accepted here"})
+		c.Abort()
+		return
+	}
+
+	prefix, ok := auth.ParseAPIKey(key)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		c.Abort()
+		return
+	}
+
+	p, err := repo.Authenticate(c.Request.Context(), prefix, auth.HashToken(key), c.ClientIP())
+	if err != nil {
+		if errors.Is(err, postgres.ErrAPIKeyInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		c.Abort()
+		return
+	}
+
+	c.Set("uid", p.UserID)
+	c.Set("role", p.Role)
+	c.Set("apiKeyId", p.KeyID)
+	c.Set("scopes", p.Scopes)
+	c.Next()
+}
+
+// RequireScope restricts API key requests to keys holding scope. Requests
+// authenticated with a session token are not scoped.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, ok := c.Get("scopes")
+		if !ok {
+			c.Next()
+			return
+		}
+
+		scopes, _ := v.([]string)
+		if !slices.Contains(scopes, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "api key lacks scope " + scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// DenyImpersonation keeps impersonation tokens away from routes that manage
+// the account's own credentials.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("impersonationId"); ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not available while impersonating"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"komiac-support-backend/internal/auth"
+)
+
+// RequirePermission lets the request through when the caller's role grants
+// any of perms.
+func RequirePermission(perms ...auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, _ := c.Get("role")
+		role, _ := v.(string)
+
+		if !auth.Role(role).CanAny(perms...) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
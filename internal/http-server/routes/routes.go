@@ -4,51 +4,145 @@ import (
 	"github.com/gin-gonic/gin"
 
 	authapi "komiac-support-backend/internal/api/auth"
+	deptsapi "komiac-support-backend/internal/api/depts"
 	ticketsapi "komiac-support-backend/internal/api/tickets"
 	usersapi "komiac-support-backend/internal/api/users"
 
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/blob"
 	"komiac-support-backend/internal/config"
 	"komiac-support-backend/internal/http-server/middleware"
+	"komiac-support-backend/internal/mail"
 	postgres "komiac-support-backend/internal/storage"
 )
 
-func Register(r *gin.Engine, cfg config.Config, users *postgres.UsersRepo, tickets *postgres.TicketsRepo) {
+type Deps struct {
+	Users          *postgres.UsersRepo
+	Tickets        *postgres.TicketsRepo
+	Depts          *postgres.DeptsRepo
+	Sessions       *postgres.SessionsRepo
+	PasswordResets *postgres.PasswordResetsRepo
+	Verifications  *postgres.EmailVerificationsRepo
+	LoginAttempts  *postgres.LoginAttemptsRepo
+	APIKeys        *postgres.APIKeysRepo
+	Impersonations *postgres.ImpersonationsRepo
+	Blobs          blob.Store
+	Mailer         mail.Sender
+	Authenticator  auth.Authenticator
+	OIDC           *auth.OIDC
+	Keys           *auth.KeySet
+}
+
+func Register(r *gin.Engine, cfg config.Config, d Deps) {
 	r.Use(middleware.CORS(middleware.CORSConfig{Origin: cfg.CorsOrigin}))
 
-	authH := authapi.New(cfg, users)
-	ticketsH := ticketsapi.New(tickets)
-	usersH := usersapi.New(users)
+	authH := authapi.New(cfg, d.Users, d.Depts, d.Sessions, d.PasswordResets, d.Verifications, d.LoginAttempts, d.APIKeys, d.Impersonations, d.Mailer, d.Authenticator, d.OIDC, d.Keys)
+	ticketsH := ticketsapi.New(cfg, d.Tickets, d.Blobs)
+	deptsH := deptsapi.New(d.Depts, d.Users)
+	usersH := usersapi.New(cfg, d.Users, d.Depts, d.LoginAttempts, d.Tickets, d.PasswordResets, d.Mailer)
+
+	authMW := middleware.RequireAuth(middleware.AuthConfig{Keys: d.Keys, Impersonations: d.Impersonations})
+	passwordChangeMW := middleware.RequireAuth(middleware.AuthConfig{Keys: d.Keys, AllowPasswordChange: true, Impersonations: d.Impersonations})
+	apiMW := middleware.RequireAuth(middleware.AuthConfig{Keys: d.Keys, APIKeys: d.APIKeys, Impersonations: d.Impersonations})
+	selfMW := middleware.DenyImpersonation()
+
+	ticketsRead := middleware.RequireScope(auth.ScopeTicketsRead)
+	ticketsWrite := middleware.RequireScope(auth.ScopeTicketsWrite)
+	usersRead := middleware.RequireScope(auth.ScopeUsersRead)
+
+	can := middleware.RequirePermission
+
+	r.GET("/.well-known/jwks.json", authH.JWKS)
 
 	g := r.Group("/auth")
 	{
 		g.POST("/login", authH.Login)
+		g.POST("/login/mfa", authH.LoginMFA)
+		g.POST("/login/mfa/setup", authH.LoginMFASetup)
+		g.POST("/login/mfa/enroll", authH.LoginMFAEnroll)
 		g.POST("/refresh", authH.Refresh)
 		g.POST("/logout", authH.Logout)
+		g.GET("/oidc/login", authH.OIDCLogin)
+		g.GET("/oidc/callback", authH.OIDCCallback)
+		g.POST("/password/forgot", authH.ForgotPassword)
+		g.POST("/password/reset", authH.ResetPassword)
+
+		g.GET("/register", authH.RegistrationInfo)
+		g.POST("/register", authH.Register)
+		g.POST("/email/verify", authH.VerifyEmail)
 
-		g.GET("/me",
-			middleware.RequireAuth(middleware.AuthConfig{AccessSecret: cfg.AccessSecret}),
-			authH.Me,
-		)
+		g.GET("/me", passwordChangeMW, authH.Me)
+		g.PATCH("/me", authMW, selfMW, authH.UpdateMe)
+		g.POST("/me/password", passwordChangeMW, selfMW, authH.ChangePassword)
+
+		g.POST("/mfa/totp/setup", authMW, selfMW, authH.TOTPSetup)
+		g.POST("/mfa/totp/enable", authMW, selfMW, authH.TOTPEnable)
+		g.POST("/mfa/totp/disable", authMW, selfMW, authH.TOTPDisable)
+		g.POST("/mfa/recovery-codes", authMW, selfMW, authH.RegenerateRecoveryCodes)
+
+		g.GET("/sessions", authMW, selfMW, authH.ListSessions)
+		g.DELETE("/sessions", authMW, selfMW, authH.RevokeAllSessions)
+		g.DELETE("/sessions/:id", authMW, selfMW, authH.RevokeSession)
+
+		g.GET("/api-keys", authMW, selfMW, authH.ListAPIKeys)
+		g.POST("/api-keys", authMW, selfMW, authH.CreateAPIKey)
+		g.DELETE("/api-keys/:id", authMW, selfMW, authH.RevokeAPIKey)
 	}
 
-	authMW := middleware.RequireAuth(middleware.AuthConfig{AccessSecret: cfg.AccessSecret})
+	u := r.Group("/users")
+	{
+		u.GET("", authMW, can(auth.PermUserManage), usersH.ListUsers)
+		u.POST("", authMW, can(auth.PermUserManage), usersH.CreateUser)
+		u.POST("/import", authMW, can(auth.PermUserManage), usersH.ImportUsers)
+		u.GET("/:id", authMW, can(auth.PermUserManage), usersH.GetUser)
+		u.PATCH("/:id", authMW, can(auth.PermUserManage), usersH.UpdateUser)
+		u.POST("/:id/deactivate", authMW, can(auth.PermUserManage), usersH.DeactivateUser)
+		u.POST("/:id/reactivate", authMW, can(auth.PermUserManage), usersH.ReactivateUser)
+		u.POST("/:id/approve", authMW, can(auth.PermUserManage), usersH.ApproveRegistration)
+		u.POST("/:id/reject", authMW, can(auth.PermUserManage), usersH.RejectRegistration)
+		u.PUT("/:id/external-identity", authMW, can(auth.PermUserManage), usersH.LinkExternal)
+		u.GET("/:id/tickets/stats", authMW, can(auth.PermUserManage), usersH.TicketStats)
+
+		u.GET("/impersonations", authMW, can(auth.PermUserImpersonate), authH.ListImpersonations)
+		u.POST("/impersonations/:id/end", authMW, can(auth.PermUserImpersonate), authH.EndImpersonation)
+		u.POST("/:id/impersonate", authMW, selfMW, can(auth.PermUserImpersonate), authH.Impersonate)
+
+		u.GET("/support", apiMW, usersRead, can(auth.PermUserView), usersH.ListSupportUsers)
+		u.GET("/:id/login-attempts", apiMW, usersRead, can(auth.PermUserView), usersH.ListLoginAttempts)
+		u.POST("/:id/unlock", authMW, can(auth.PermUserUnlock), usersH.Unlock)
+	}
 
-	u := r.Group("/users", authMW)
+	r.GET("/depts", deptsH.ListPublic)
+	dp := r.Group("/depts", authMW, can(auth.PermDeptManage))
 	{
-		u.GET("/support", usersH.ListSupportUsers)
+		dp.GET("/manage", deptsH.List)
+		dp.GET("/:id", deptsH.Get)
+		dp.POST("", deptsH.Create)
+		dp.PATCH("/:id", deptsH.Update)
+		dp.DELETE("/:id", deptsH.Delete)
 	}
 
-	t := r.Group("/tickets", authMW)
+	t := r.Group("/tickets", apiMW)
 	{
-		t.GET("", ticketsH.ListTickets)
-		t.GET("/my", ticketsH.ListMyTickets)
-		t.GET("/my/:id", ticketsH.GetMyTicket)
-		t.GET("/:id", ticketsH.GetTicket)
-		t.POST("", ticketsH.CreateTicket)
-		t.POST("/:id/assign", ticketsH.AssignTicket)
-		t.POST("/:id/messages", ticketsH.AddMessage)
-		t.GET("/:id/messages", ticketsH.ListMessages)
-		t.POST("/:id/reply", ticketsH.ReplyTicket)
-		t.POST("/:id/close", ticketsH.CloseTicket)
+		t.GET("", ticketsRead, can(auth.PermTicketViewAll, auth.PermTicketViewDept), ticketsH.ListTickets)
+		t.GET("/my", ticketsRead, ticketsH.ListMyTickets)
+		t.GET("/my/:id", ticketsRead, ticketsH.GetMyTicket)
+		t.GET("/by-number/:number", ticketsRead, ticketsH.GetTicketByNumber)
+		t.GET("/my/:id/messages", ticketsRead, ticketsH.ListMyMessages)
+		t.POST("/my/:id/messages", ticketsWrite, ticketsH.AddMyMessage)
+		t.POST("/my/:id/confirm", ticketsWrite, ticketsH.ConfirmMyTicket)
+		t.POST("/my/:id/reopen", ticketsWrite, ticketsH.ReopenMyTicket)
+		t.GET("/my/:id/attachments", ticketsRead, ticketsH.ListMyAttachments)
+		t.GET("/my/:id/attachments/:attachmentId", ticketsRead, ticketsH.DownloadMyAttachment)
+		t.GET("/:id", ticketsRead, can(auth.PermTicketViewAll, auth.PermTicketViewDept), ticketsH.GetTicket)
+		t.POST("", ticketsWrite, can(auth.PermTicketCreate), ticketsH.CreateTicket)
+		t.POST("/:id/assign", ticketsWrite, can(auth.PermTicketAssign), ticketsH.AssignTicket)
+		t.POST("/:id/messages", ticketsWrite, can(auth.PermTicketReply), ticketsH.AddMessage)
+		t.GET("/:id/messages", ticketsRead, can(auth.PermTicketViewAll, auth.PermTicketViewDept), ticketsH.ListMessages)
+		t.GET("/:id/attachments", ticketsRead, can(auth.PermTicketViewAll, auth.PermTicketViewDept), ticketsH.ListAttachments)
+		t.GET("/:id/attachments/:attachmentId", ticketsRead, can(auth.PermTicketViewAll, auth.PermTicketViewDept), ticketsH.DownloadAttachment)
+		t.POST("/:id/reply", ticketsWrite, can(auth.PermTicketReply), ticketsH.ReplyTicket)
+		t.POST("/:id/close", ticketsWrite, can(auth.PermTicketClose), ticketsH.CloseTicket)
+		t.POST("/:id/transition", ticketsWrite, can(auth.PermTicketReply), ticketsH.TransitionTicket)
 	}
 }
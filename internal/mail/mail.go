@@ -0,0 +1,134 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+type Config struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUser     string
+	SMTPPassword string
+	Dir          string
+}
+
+func New(cfg Config) (Sender, error) {
+	switch cfg.Driver {
+	case "", "log":
+		return LogSender{}, nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("mail: MAIL_DIR is required for the file driver")
+		}
+		return FileSender{Dir: cfg.Dir, From: cfg.From}, nil
+	case "smtp":
+		if cfg.SMTPHost == "" || cfg.From == "" {
+			return nil, fmt.Errorf("mail: SMTP_HOST and MAIL_FROM are required for the smtp driver")
+		}
+		return SMTPSender{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			User:     cfg.SMTPUser,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}, nil
+	default:
+		return nil, fmt.Errorf("mail: unknown driver %q", cfg.Driver)
+	}
+}
+
+type LogSender struct{}
+
+func (LogSender) Send(_ context.Context, m Message) error {
+	log.Printf("mail to=%s subject=%q\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s FileSender) Send(_ context.Context, m Message) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("mail dir: %w", err)
+	}
+
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102-150405.000000000"), sanitizeFileName(m.To))
+	return os.WriteFile(filepath.Join(s.Dir, name), buildMessage(s.From, m), 0o644)
+}
+
+type SMTPSender struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+}
+
+func (s SMTPSender) Send(_ context.Context, m Message) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+
+	var a smtp.Auth
+	if s.User != "" {
+		a = smtp.PlainAuth("", s.User, s.Password, s.Host)
+	}
+
+	if err := smtp.SendMail(addr, a, s.From, []string{m.To}, buildMessage(s.From, m)); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+	return nil
+}
+
+func buildMessage(from string, m Message) []byte {
+	var b strings.Builder
+	if from != "" {
+		b.WriteString("From: " + from + "\r\n")
+	}
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + encodeHeader(m.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func encodeHeader(s string) string {
+	return mime.BEncoding.Encode("UTF-8", s)
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrAPIKeyInvalid = errors.New("api key invalid, expired or revoked")
+
+type APIKey struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"createdAt"`
+	ExpiresAt  *string  `json:"expiresAt"`
+	LastUsedAt *string  `json:"lastUsedAt"`
+	LastUsedIP string   `json:"lastUsedIp"`
+}
+
+type CreateAPIKeyParams struct {
+	UserID    int64
+	Name      string
+	Prefix    string
+	KeyHash   string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// APIKeyPrincipal is who a presented key acts as.
+type APIKeyPrincipal struct {
+	KeyID  int64
+	UserID int64
+	Role   string
+	Scopes []string
+}
+
+type APIKeysRepo struct {
+	db *sql.DB
+}
+
+func NewAPIKeysRepo(db *sql.DB) *APIKeysRepo {
+	return &APIKeysRepo{db: db}
+}
+
+func (r *APIKeysRepo) Create(ctx context.Context, p CreateAPIKeyParams) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+INSERT INTO api_keys(user_id, name, prefix, key_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id;
+`, p.UserID, p.Name, p.Prefix, p.KeyHash, pq.Array(p.Scopes), p.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+func (r *APIKeysRepo) ListForUser(ctx context.Context, userID int64) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at, COALESCE(last_used_ip, '')
+FROM api_keys
+WHERE user_id = $1
+  AND revoked_at IS NULL
+  AND (expires_at IS NULL OR expires_at > NOW())
+ORDER BY created_at DESC, id DESC;
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []APIKey
+	for rows.Next() {
+		var (
+			k                 APIKey
+			created           time.Time
+			expires, lastUsed sql.NullTime
+		)
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &created, &expires, &lastUsed, &k.LastUsedIP); err != nil {
+			return nil, err
+		}
+
+		k.CreatedAt = created.Format("15:04 02.01.2006")
+		if expires.Valid {
+			s := expires.Time.Format("15:04 02.01.2006")
+			k.ExpiresAt = &s
+		}
+		if lastUsed.Valid {
+			s := lastUsed.Time.Format("15:04 02.01.2006")
+			k.LastUsedAt = &s
+		}
+
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func (r *APIKeysRepo) Revoke(ctx context.Context, userID, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+`, id, userID)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+// Authenticate resolves a key by its prefix, checks the stored hash and
+// records the use. last_used_* is written at most once a minute per key.
+func (r *APIKeysRepo) Authenticate(ctx context.Context, prefix, keyHash, ip string) (*APIKeyPrincipal, error) {
+	var (
+		p    APIKeyPrincipal
+		hash string
+	)
+	err := r.db.QueryRowContext(ctx, `
+SELECT k.id, k.user_id, u.role, k.scopes, k.key_hash
+FROM api_keys k
+JOIN users u ON u.id = k.user_id
+WHERE k.prefix = $1
+  AND k.revoked_at IS NULL
+  AND u.deactivated_at IS NULL
+  AND (k.expires_at IS NULL OR k.expires_at > NOW());
+`, prefix).Scan(&p.KeyID, &p.UserID, &p.Role, pq.Array(&p.Scopes), &hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAPIKeyInvalid
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(keyHash)) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if _, err := r.db.ExecContext(ctx, `
+UPDATE api_keys
+SET last_used_at = NOW(), last_used_ip = $2
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+`, p.KeyID, ip); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrDeptNameTaken = errors.New("dept name already in use")
+	ErrDeptCycle     = errors.New("dept cannot be moved under itself")
+	ErrDeptInUse     = errors.New("dept has sub-departments or members")
+	ErrDeptPrefix    = errors.New("ticket prefix already in use")
+)
+
+type Dept struct {
+	ID           int64   `json:"id"`
+	Name         string  `json:"name"`
+	Path         string  `json:"path"`
+	Phone        *string `json:"phone,omitempty"`
+	TicketPrefix *string `json:"ticketPrefix,omitempty"`
+	ParentID     *int64  `json:"parentId,omitempty"`
+	HeadUserID   *int64  `json:"headUserId,omitempty"`
+	HeadName     *string `json:"headName,omitempty"`
+	MemberCount  int     `json:"memberCount"`
+	CreatedAt    string  `json:"createdAt"`
+}
+
+type DeptParams struct {
+	Name  *string
+	Phone *string
+	// TicketPrefix set to "" clears it.
+	TicketPrefix *string
+	// ParentID and HeadUserID set to 0 clear the link.
+	ParentID   *int64
+	HeadUserID *int64
+}
+
+type DeptsRepo struct {
+	db *sql.DB
+}
+
+func NewDeptsRepo(db *sql.DB) *DeptsRepo {
+	return &DeptsRepo{db: db}
+}
+
+const deptSelect = `
+WITH RECURSIVE tree AS (
+  SELECT id, name::TEXT AS path
+  FROM depts
+  WHERE parent_id IS NULL
+  UNION ALL
+  SELECT d.id, tree.path || ' / ' || d.name
+  FROM depts d
+  JOIN tree ON tree.id = d.parent_id
+)
+SELECT
+  d.id,
+  d.name,
+  COALESCE(tree.path, d.name),
+  d.phone,
+  d.ticket_prefix,
+  d.parent_id,
+  d.head_user_id,
+  h.first_name,
+  h.last_name,
+  (SELECT COUNT(*) FROM users m WHERE m.dept_id = d.id),
+  d.created_at
+FROM depts d
+LEFT JOIN tree ON tree.id = d.id
+LEFT JOIN users h ON h.id = d.head_user_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDept(row rowScanner) (Dept, error) {
+	var (
+		d        Dept
+		hFn, hLn sql.NullString
+		created  sql.NullTime
+	)
+	if err := row.Scan(&d.ID, &d.Name, &d.Path, &d.Phone, &d.TicketPrefix, &d.ParentID, &d.HeadUserID, &hFn, &hLn, &d.MemberCount, &created); err != nil {
+		return Dept{}, err
+	}
+
+	if hFn.Valid || hLn.Valid {
+		s := strings.TrimSpace(hFn.String + " " + hLn.String)
+		d.HeadName = &s
+	}
+	if created.Valid {
+		d.CreatedAt = created.Time.Format("15:04 02.01.2006")
+	}
+	return d, nil
+}
+
+func (r *DeptsRepo) List(ctx context.Context) ([]Dept, error) {
+	rows, err := r.db.QueryContext(ctx, deptSelect+`ORDER BY 3;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Dept
+	for rows.Next() {
+		d, err := scanDept(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (r *DeptsRepo) Get(ctx context.Context, id int64) (Dept, error) {
+	return scanDept(r.db.QueryRowContext(ctx, deptSelect+`WHERE d.id = $1;`, id))
+}
+
+func (r *DeptsRepo) Create(ctx context.Context, p DeptParams) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := lockDepts(ctx, tx); err != nil {
+		return 0, err
+	}
+	if err := checkDeptName(ctx, tx, *p.Name, 0); err != nil {
+		return 0, err
+	}
+	if err := checkDeptPrefix(ctx, tx, p.TicketPrefix, 0); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO depts(name, phone, ticket_prefix, parent_id, head_user_id)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id;
+`, *p.Name, optString(p.Phone), optString(p.TicketPrefix), optID(p.ParentID), optID(p.HeadUserID)).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (r *DeptsRepo) Update(ctx context.Context, id int64, p DeptParams) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := lockDepts(ctx, tx); err != nil {
+		return err
+	}
+
+	set := []string{}
+	args := []any{}
+	n := 1
+
+	add := func(col string, v any) {
+		set = append(set, fmt.Sprintf("%s = $%d", col, n))
+		args = append(args, v)
+		n++
+	}
+
+	if p.Name != nil {
+		if err := checkDeptName(ctx, tx, *p.Name, id); err != nil {
+			return err
+		}
+		add("name", *p.Name)
+	}
+	if p.Phone != nil {
+		add("phone", optString(p.Phone))
+	}
+	if p.TicketPrefix != nil {
+		if err := checkDeptPrefix(ctx, tx, p.TicketPrefix, id); err != nil {
+			return err
+		}
+		add("ticket_prefix", optString(p.TicketPrefix))
+	}
+	if p.ParentID != nil {
+		if *p.ParentID != 0 {
+			var cycle bool
+			err := tx.QueryRowContext(ctx, `
+WITH RECURSIVE up AS (
+  SELECT id, parent_id FROM depts WHERE id = $1
+  UNION ALL
+  SELECT d.id, d.parent_id FROM depts d JOIN up ON d.id = up.parent_id
+)
+SELECT EXISTS (SELECT 1 FROM up WHERE id = $2);
+`, *p.ParentID, id).Scan(&cycle)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return ErrDeptCycle
+			}
+		}
+		add("parent_id", optID(p.ParentID))
+	}
+	if p.HeadUserID != nil {
+		add("head_user_id", optID(p.HeadUserID))
+	}
+
+	args = append(args, id)
+	q := `UPDATE depts SET ` + strings.Join(append(set, "updated_at = NOW()"), ", ") + ` WHERE id = $` + fmt.Sprint(n) + `;`
+
+	res, err := tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	if err := affectedOrNoRows(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes an empty department. Departments that still have
+// sub-departments or members must be emptied first.
+func (r *DeptsRepo) Delete(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := lockDepts(ctx, tx); err != nil {
+		return err
+	}
+
+	var inUse bool
+	err = tx.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM depts WHERE parent_id = $1)
+    OR EXISTS (SELECT 1 FROM users WHERE dept_id = $1);
+`, id).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrDeptInUse
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM depts WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+	if err := affectedOrNoRows(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockDepts serialises structural changes so concurrent moves cannot build a
+// cycle that neither of them sees on its own.
+func lockDepts(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `LOCK TABLE depts IN SHARE ROW EXCLUSIVE MODE;`)
+	return err
+}
+
+func checkDeptName(ctx context.Context, tx *sql.Tx, name string, exceptID int64) error {
+	var taken bool
+	err := tx.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM depts WHERE lower(name) = lower($1) AND id <> $2);
+`, name, exceptID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDeptNameTaken
+	}
+	return nil
+}
+
+func checkDeptPrefix(ctx context.Context, tx *sql.Tx, prefix *string, exceptID int64) error {
+	if prefix == nil || *prefix == "" {
+		return nil
+	}
+	var taken bool
+	err := tx.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM depts WHERE ticket_prefix = $1 AND id <> $2);
+`, *prefix, exceptID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDeptPrefix
+	}
+	return nil
+}
+
+func optString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	return nullIfEmpty(*s)
+}
+
+func optID(id *int64) *int64 {
+	if id == nil || *id == 0 {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrVerificationInvalid = errors.New("verification token invalid or expired")
+	ErrEmailTaken          = errors.New("email already in use")
+)
+
+type EmailVerificationsRepo struct {
+	db *sql.DB
+}
+
+func NewEmailVerificationsRepo(db *sql.DB) *EmailVerificationsRepo {
+	return &EmailVerificationsRepo{db: db}
+}
+
+// Create records a pending address for the user; the address only replaces
+// users.email once the token is consumed.
+func (r *EmailVerificationsRepo) Create(ctx context.Context, userID int64, email, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE email_verifications
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+`, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO email_verifications(user_id, email, token_hash, expires_at)
+VALUES ($1, $2, $3, $4);
+`, userID, email, tokenHash, expiresAt); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users
+SET pending_email = CASE WHEN lower(email) = lower($1) THEN NULL ELSE $1 END,
+    updated_at = NOW()
+WHERE id = $2;
+`, email, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *EmailVerificationsRepo) Consume(ctx context.Context, tokenHash string) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		id     int64
+		userID int64
+		email  string
+	)
+	err = tx.QueryRowContext(ctx, `
+SELECT id, user_id, email
+FROM email_verifications
+WHERE token_hash = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+FOR UPDATE;
+`, tokenHash).Scan(&id, &userID, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrVerificationInvalid
+		}
+		return 0, err
+	}
+
+	var taken bool
+	if err := tx.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1) AND id <> $2);
+`, email, userID).Scan(&taken); err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, ErrEmailTaken
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE email_verifications SET used_at = NOW() WHERE id = $1;`, id); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users
+SET email = $1,
+    pending_email = NULL,
+    email_verified_at = NOW(),
+    updated_at = NOW()
+WHERE id = $2;
+`, email, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
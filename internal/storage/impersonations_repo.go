@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Impersonation struct {
+	ID         int64   `json:"id"`
+	ActorID    int64   `json:"actorId"`
+	ActorName  string  `json:"actorName"`
+	TargetID   int64   `json:"targetId"`
+	TargetName string  `json:"targetName"`
+	Reason     string  `json:"reason"`
+	ReadOnly   bool    `json:"readOnly"`
+	IP         string  `json:"ip"`
+	UserAgent  string  `json:"userAgent"`
+	Requests   int     `json:"requests"`
+	LastSeenAt *string `json:"lastSeenAt,omitempty"`
+	StartedAt  string  `json:"startedAt"`
+	ExpiresAt  string  `json:"expiresAt"`
+	EndedAt    *string `json:"endedAt,omitempty"`
+	Active     bool    `json:"active"`
+}
+
+type StartImpersonationParams struct {
+	ActorID   int64
+	TargetID  int64
+	Reason    string
+	ReadOnly  bool
+	IP        string
+	UserAgent string
+	ExpiresAt time.Time
+}
+
+type ListImpersonationsParams struct {
+	ActorID  int64
+	TargetID int64
+	Limit    int
+	Offset   int
+}
+
+type ImpersonationsRepo struct {
+	db *sql.DB
+}
+
+func NewImpersonationsRepo(db *sql.DB) *ImpersonationsRepo {
+	return &ImpersonationsRepo{db: db}
+}
+
+const impersonationColumns = `
+  i.id,
+  i.actor_id,
+  a.first_name,
+  a.last_name,
+  i.target_id,
+  t.first_name,
+  t.last_name,
+  i.reason,
+  i.read_only,
+  i.ip,
+  COALESCE(i.user_agent, ''),
+  i.requests,
+  i.last_seen_at,
+  i.started_at,
+  i.expires_at,
+  i.ended_at,
+  i.ended_at IS NULL AND i.expires_at > NOW()`
+
+const impersonationFrom = `
+FROM impersonations i
+JOIN users a ON a.id = i.actor_id
+JOIN users t ON t.id = i.target_id
+`
+
+func scanImpersonation(row rowScanner, extra ...any) (Impersonation, error) {
+	var (
+		im               Impersonation
+		aFn, aLn         string
+		tFn, tLn         string
+		lastSeen, ended  sql.NullTime
+		started, expires time.Time
+	)
+	dest := []any{
+		&im.ID, &im.ActorID, &aFn, &aLn, &im.TargetID, &tFn, &tLn, &im.Reason, &im.ReadOnly,
+		&im.IP, &im.UserAgent, &im.Requests, &lastSeen, &started, &expires, &ended, &im.Active,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Impersonation{}, err
+	}
+
+	im.ActorName = strings.TrimSpace(aFn + " " + aLn)
+	im.TargetName = strings.TrimSpace(tFn + " " + tLn)
+	im.StartedAt = started.Format("15:04 02.01.2006")
+	im.ExpiresAt = expires.Format("15:04 02.01.2006")
+	if lastSeen.Valid {
+		s := lastSeen.Time.Format("15:04 02.01.2006")
+		im.LastSeenAt = &s
+	}
+	if ended.Valid {
+		s := ended.Time.Format("15:04 02.01.2006")
+		im.EndedAt = &s
+	}
+	return im, nil
+}
+
+func (r *ImpersonationsRepo) Start(ctx context.Context, p StartImpersonationParams) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+INSERT INTO impersonations(actor_id, target_id, reason, read_only, ip, user_agent, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id;
+`, p.ActorID, p.TargetID, p.Reason, p.ReadOnly, p.IP, nullIfEmpty(p.UserAgent), p.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+func (r *ImpersonationsRepo) Get(ctx context.Context, id int64) (Impersonation, error) {
+	return scanImpersonation(r.db.QueryRowContext(ctx, `SELECT`+impersonationColumns+impersonationFrom+`WHERE i.id = $1;`, id))
+}
+
+// Touch counts a request made with the impersonation token. It reports false
+// once the session has ended or expired, or its actor has been deactivated.
+func (r *ImpersonationsRepo) Touch(ctx context.Context, id int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE impersonations i
+SET requests = requests + 1,
+    last_seen_at = NOW()
+FROM users a
+WHERE i.id = $1
+  AND a.id = i.actor_id
+  AND a.deactivated_at IS NULL
+  AND i.ended_at IS NULL
+  AND i.expires_at > NOW();
+`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *ImpersonationsRepo) End(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE impersonations
+SET ended_at = NOW()
+WHERE id = $1 AND ended_at IS NULL;
+`, id)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *ImpersonationsRepo) List(ctx context.Context, p ListImpersonationsParams) ([]Impersonation, int, error) {
+	where := []string{}
+	args := []any{}
+	n := 1
+
+	if p.ActorID != 0 {
+		where = append(where, fmt.Sprintf("i.actor_id = $%d", n))
+		args = append(args, p.ActorID)
+		n++
+	}
+	if p.TargetID != 0 {
+		where = append(where, fmt.Sprintf("i.target_id = $%d", n))
+		args = append(args, p.TargetID)
+		n++
+	}
+
+	w := ""
+	if len(where) > 0 {
+		w = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	args = append(args, p.Limit, p.Offset)
+	q := `SELECT` + impersonationColumns + `,
+  COUNT(*) OVER ()` + impersonationFrom + w + `
+ORDER BY i.started_at DESC, i.id DESC
+LIMIT $` + fmt.Sprint(n) + ` OFFSET $` + fmt.Sprint(n+1) + `;
+`
+
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		out   []Impersonation
+		total int
+	)
+	for rows.Next() {
+		im, err := scanImpersonation(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, im)
+	}
+	return out, total, rows.Err()
+}
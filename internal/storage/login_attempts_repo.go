@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type LoginAttempt struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	UserID    *int64 `json:"userId,omitempty"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Success   bool   `json:"success"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type LoginAttemptsRepo struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptsRepo(db *sql.DB) *LoginAttemptsRepo {
+	return &LoginAttemptsRepo{db: db}
+}
+
+func (r *LoginAttemptsRepo) Record(ctx context.Context, a LoginAttempt) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO login_attempts(login, user_id, ip, user_agent, success, reason)
+VALUES ($1, $2, $3, $4, $5, $6);
+`, a.Login, a.UserID, a.IP, a.UserAgent, a.Success, a.Reason)
+	return err
+}
+
+func (r *LoginAttemptsRepo) CountIPFailures(ctx context.Context, ip string, since time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM login_attempts
+WHERE ip = $1 AND success = FALSE AND created_at >= $2;
+`, ip, since).Scan(&n)
+	return n, err
+}
+
+func (r *LoginAttemptsRepo) ListForUser(ctx context.Context, userID int64, limit int) ([]LoginAttempt, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, login, user_id, ip, COALESCE(user_agent, ''), success, reason, created_at
+FROM login_attempts
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC
+LIMIT $2;
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LoginAttempt
+	for rows.Next() {
+		var (
+			a       LoginAttempt
+			uid     sql.NullInt64
+			created time.Time
+		)
+		if err := rows.Scan(&a.ID, &a.Login, &uid, &a.IP, &a.UserAgent, &a.Success, &a.Reason, &created); err != nil {
+			return nil, err
+		}
+		if uid.Valid {
+			a.UserID = &uid.Int64
+		}
+		a.CreatedAt = created.Format("15:04 02.01.2006")
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
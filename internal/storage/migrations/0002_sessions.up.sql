@@ -0,0 +1,11 @@
+CREATE TABLE sessions (
+	jti VARCHAR(64) PRIMARY KEY,
+	family_id VARCHAR(64) NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	rotated_at TIMESTAMP NULL,
+	revoked_at TIMESTAMP NULL
+);
+CREATE INDEX idx_sessions_family_id ON sessions(family_id);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
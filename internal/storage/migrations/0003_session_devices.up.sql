@@ -0,0 +1,2 @@
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NULL;
+ALTER TABLE sessions ADD COLUMN ip VARCHAR(64) NULL;
@@ -0,0 +1,18 @@
+CREATE TABLE password_reset_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP NULL
+);
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+
+CREATE TABLE password_reset_requests (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,
+	ip VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_password_reset_requests_user_id ON password_reset_requests(user_id, created_at);
+CREATE INDEX idx_password_reset_requests_ip ON password_reset_requests(ip, created_at);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(100) NULL;
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP NULL;
+UPDATE users SET email_verified_at = COALESCE(created_at, NOW());
+
+CREATE TABLE email_verifications (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	email VARCHAR(100) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP NULL
+);
+CREATE INDEX idx_email_verifications_user_id ON email_verifications(user_id);
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMP NULL;
+
+CREATE TABLE login_attempts (
+	id BIGSERIAL PRIMARY KEY,
+	login VARCHAR(100) NOT NULL,
+	user_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+	ip VARCHAR(64) NOT NULL,
+	user_agent TEXT NULL,
+	success BOOLEAN NOT NULL,
+	reason VARCHAR(32) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_login_attempts_user_id ON login_attempts(user_id, created_at);
+CREATE INDEX idx_login_attempts_ip ON login_attempts(ip, created_at);
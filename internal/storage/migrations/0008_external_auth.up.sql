@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN auth_source VARCHAR(20) NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN external_id VARCHAR(255) NULL;
+CREATE UNIQUE INDEX idx_users_auth_source_external_id ON users(auth_source, external_id) WHERE external_id IS NOT NULL;
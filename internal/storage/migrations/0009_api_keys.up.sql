@@ -0,0 +1,14 @@
+CREATE TABLE api_keys (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	prefix VARCHAR(16) NOT NULL UNIQUE,
+	key_hash CHAR(64) NOT NULL,
+	scopes TEXT[] NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NULL,
+	last_used_at TIMESTAMP NULL,
+	last_used_ip VARCHAR(64) NULL,
+	revoked_at TIMESTAMP NULL
+);
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
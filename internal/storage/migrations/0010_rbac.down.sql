@@ -0,0 +1,6 @@
+UPDATE users SET role = 'support' WHERE role = 'admin';
+UPDATE users SET role = 'user' WHERE role = 'dept_lead';
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check
+  CHECK (role IN ('user', 'support'));
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP NULL;
+ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_depts_parent_id;
+
+ALTER TABLE depts DROP CONSTRAINT IF EXISTS depts_parent_not_self;
+ALTER TABLE depts DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE depts DROP COLUMN IF EXISTS head_user_id;
+ALTER TABLE depts DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,6 @@
+ALTER TABLE depts ADD COLUMN parent_id INTEGER NULL REFERENCES depts(id) ON DELETE RESTRICT;
+ALTER TABLE depts ADD COLUMN head_user_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE depts ADD COLUMN updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE depts ADD CONSTRAINT depts_parent_not_self CHECK (parent_id IS NULL OR parent_id <> id);
+
+CREATE INDEX IF NOT EXISTS idx_depts_parent_id ON depts(parent_id);
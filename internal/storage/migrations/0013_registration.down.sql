@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_users_registration_ip;
+DROP INDEX IF EXISTS idx_users_registration_status;
+
+DELETE FROM users WHERE registration_status IS NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS registration_ip;
+ALTER TABLE users DROP COLUMN IF EXISTS registration_status;
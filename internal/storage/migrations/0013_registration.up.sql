@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN registration_status VARCHAR(20) NULL
+	CHECK (registration_status IN ('pending_verification', 'pending_approval'));
+ALTER TABLE users ADD COLUMN registration_ip VARCHAR(45) NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_registration_status ON users(registration_status)
+	WHERE registration_status IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_users_registration_ip ON users(registration_ip, created_at)
+	WHERE registration_ip IS NOT NULL;
@@ -0,0 +1,16 @@
+CREATE TABLE impersonations (
+	id BIGSERIAL PRIMARY KEY,
+	actor_id INTEGER NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	target_id INTEGER NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	reason TEXT NOT NULL,
+	read_only BOOLEAN NOT NULL DEFAULT TRUE,
+	ip VARCHAR(64) NOT NULL,
+	user_agent TEXT NULL,
+	requests INTEGER NOT NULL DEFAULT 0,
+	last_seen_at TIMESTAMP NULL,
+	started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	ended_at TIMESTAMP NULL
+);
+CREATE INDEX idx_impersonations_actor_id ON impersonations(actor_id, started_at);
+CREATE INDEX idx_impersonations_target_id ON impersonations(target_id, started_at);
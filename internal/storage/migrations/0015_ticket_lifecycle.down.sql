@@ -0,0 +1,2 @@
+ALTER TABLE tickets DROP CONSTRAINT IF EXISTS tickets_resolved_at_status;
+ALTER TABLE tickets DROP CONSTRAINT IF EXISTS tickets_closed_at_status;
@@ -0,0 +1,13 @@
+UPDATE tickets SET closed_at = NULL WHERE status <> 'closed';
+UPDATE tickets SET closed_at = COALESCE(updated_at, created_at, NOW()) WHERE status = 'closed' AND closed_at IS NULL;
+UPDATE tickets SET resolved_at = NULL WHERE status NOT IN ('resolved', 'closed');
+UPDATE tickets SET resolved_at = COALESCE(updated_at, created_at, NOW()) WHERE status = 'resolved' AND resolved_at IS NULL;
+
+ALTER TABLE tickets ADD CONSTRAINT tickets_closed_at_status
+	CHECK ((status = 'closed') = (closed_at IS NOT NULL));
+ALTER TABLE tickets ADD CONSTRAINT tickets_resolved_at_status
+	CHECK (
+		(status = 'resolved' AND resolved_at IS NOT NULL)
+		OR status = 'closed'
+		OR (status NOT IN ('resolved', 'closed') AND resolved_at IS NULL)
+	);
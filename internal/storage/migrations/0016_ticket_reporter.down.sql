@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_tickets_awaiting_support;
+
+ALTER TABLE tickets DROP COLUMN IF EXISTS awaiting_support;
@@ -0,0 +1,5 @@
+ALTER TABLE tickets ADD COLUMN awaiting_support BOOLEAN NOT NULL DEFAULT TRUE;
+UPDATE tickets SET awaiting_support = (support_reply IS NULL);
+
+CREATE INDEX idx_tickets_awaiting_support ON tickets(created_at)
+	WHERE awaiting_support AND status IN ('open', 'in_progress', 'reopened');
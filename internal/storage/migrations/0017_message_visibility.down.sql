@@ -0,0 +1,23 @@
+DROP INDEX IF EXISTS idx_ticket_messages_public;
+
+ALTER TABLE tickets ADD COLUMN support_reply TEXT NULL;
+ALTER TABLE tickets ADD COLUMN replied_at TIMESTAMP NULL;
+
+UPDATE tickets t
+SET support_reply = r.message,
+    replied_at = r.created_at
+FROM (
+	SELECT DISTINCT ON (m.ticket_id) m.ticket_id, m.message, m.created_at
+	FROM ticket_messages m
+	JOIN tickets t2 ON t2.id = m.ticket_id
+	WHERE m.visibility = 'public' AND m.author_id IS DISTINCT FROM t2.user_id
+	ORDER BY m.ticket_id, m.created_at DESC, m.id DESC
+) r
+WHERE r.ticket_id = t.id;
+
+-- Internal notes must not turn into public messages.
+DELETE FROM ticket_messages WHERE visibility = 'internal';
+DELETE FROM ticket_messages WHERE author_id IS NULL;
+
+ALTER TABLE ticket_messages ALTER COLUMN author_id SET NOT NULL;
+ALTER TABLE ticket_messages DROP COLUMN visibility;
@@ -0,0 +1,16 @@
+ALTER TABLE ticket_messages ADD COLUMN visibility VARCHAR(10) NOT NULL DEFAULT 'public'
+	CHECK (visibility IN ('public', 'internal'));
+
+-- Legacy replies whose agent was deleted keep no author.
+ALTER TABLE ticket_messages ALTER COLUMN author_id DROP NOT NULL;
+
+INSERT INTO ticket_messages (ticket_id, author_id, message, visibility, created_at)
+SELECT id, taken_by, support_reply, 'public', COALESCE(replied_at, updated_at, created_at)
+FROM tickets
+WHERE support_reply IS NOT NULL AND btrim(support_reply) <> '';
+
+ALTER TABLE tickets DROP COLUMN support_reply;
+ALTER TABLE tickets DROP COLUMN replied_at;
+
+CREATE INDEX idx_ticket_messages_public ON ticket_messages(ticket_id, created_at)
+	WHERE visibility = 'public';
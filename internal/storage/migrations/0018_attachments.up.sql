@@ -0,0 +1,13 @@
+CREATE TABLE attachments (
+	id SERIAL PRIMARY KEY,
+	ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+	message_id INTEGER NULL REFERENCES ticket_messages(id) ON DELETE CASCADE,
+	uploader_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+	file_name VARCHAR(255) NOT NULL,
+	content_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL CHECK (size >= 0),
+	storage_key VARCHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_attachments_ticket_id ON attachments(ticket_id);
+CREATE INDEX idx_attachments_message_id ON attachments(message_id) WHERE message_id IS NOT NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_attachments_scan_pending;
+
+ALTER TABLE attachments
+	DROP COLUMN IF EXISTS scanned_at,
+	DROP COLUMN IF EXISTS scan_started_at,
+	DROP COLUMN IF EXISTS scan_signature,
+	DROP COLUMN IF EXISTS scan_status;
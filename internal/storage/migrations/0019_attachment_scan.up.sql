@@ -0,0 +1,12 @@
+-- Files uploaded before scanning existed were always served, so they start
+-- out clean; new uploads default to pending.
+ALTER TABLE attachments
+	ADD COLUMN scan_status VARCHAR(10) NOT NULL DEFAULT 'clean'
+		CHECK (scan_status IN ('pending', 'clean', 'infected')),
+	ADD COLUMN scan_signature VARCHAR(255) NULL,
+	ADD COLUMN scan_started_at TIMESTAMP NULL,
+	ADD COLUMN scanned_at TIMESTAMP NULL;
+
+ALTER TABLE attachments ALTER COLUMN scan_status SET DEFAULT 'pending';
+
+CREATE INDEX idx_attachments_scan_pending ON attachments(id) WHERE scan_status = 'pending';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS ticket_counters;
+
+DROP INDEX IF EXISTS idx_depts_ticket_prefix;
+ALTER TABLE depts DROP COLUMN IF EXISTS ticket_prefix;
@@ -0,0 +1,17 @@
+ALTER TABLE depts ADD COLUMN ticket_prefix VARCHAR(10) NULL
+	CHECK (ticket_prefix ~ '^[A-Z0-9]+$');
+CREATE UNIQUE INDEX idx_depts_ticket_prefix ON depts(ticket_prefix) WHERE ticket_prefix IS NOT NULL;
+
+-- scope is the rendered template with the sequence left out, so every
+-- year/department combination the template distinguishes counts separately.
+CREATE TABLE ticket_counters (
+	scope VARCHAR(100) PRIMARY KEY,
+	last_value BIGINT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Continue the legacy zero-padded numbering.
+INSERT INTO ticket_counters (scope, last_value)
+SELECT '{seq}', COALESCE(MAX(CAST(ticket_number AS BIGINT)), 0)
+FROM tickets
+WHERE ticket_number ~ '^[0-9]{1,18}$';
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_tickets_user_created;
+DROP INDEX IF EXISTS idx_tickets_sla_due_at;
+DROP INDEX IF EXISTS idx_tickets_updated_at;
+DROP INDEX IF EXISTS idx_tickets_created_at;
+CREATE INDEX IF NOT EXISTS idx_tickets_created_at ON tickets(created_at);
+
+ALTER TABLE tickets ALTER COLUMN updated_at DROP NOT NULL;
+ALTER TABLE tickets ALTER COLUMN created_at DROP NOT NULL;
+
+ALTER TABLE tickets DROP COLUMN IF EXISTS sla_due_at;
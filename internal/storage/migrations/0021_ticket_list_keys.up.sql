@@ -0,0 +1,24 @@
+ALTER TABLE tickets ADD COLUMN sla_due_at TIMESTAMP NULL;
+
+-- Backfills unfinished tickets with the default TICKET_SLA_HOURS
+-- (high=8,medium=24,low=72); a configured value only applies to tickets
+-- created after the upgrade. Resolved and closed tickets keep no deadline.
+UPDATE tickets
+SET sla_due_at = created_at + CASE priority
+	WHEN 'high' THEN INTERVAL '8 hours'
+	WHEN 'medium' THEN INTERVAL '24 hours'
+	ELSE INTERVAL '72 hours'
+END
+WHERE status NOT IN ('resolved', 'closed');
+
+-- Sort keys for keyset pagination must never be NULL.
+UPDATE tickets SET created_at = NOW() WHERE created_at IS NULL;
+UPDATE tickets SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE tickets ALTER COLUMN created_at SET NOT NULL;
+ALTER TABLE tickets ALTER COLUMN updated_at SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_tickets_created_at;
+CREATE INDEX idx_tickets_created_at ON tickets(created_at, id);
+CREATE INDEX idx_tickets_updated_at ON tickets(updated_at, id);
+CREATE INDEX idx_tickets_sla_due_at ON tickets((COALESCE(sla_due_at, 'infinity'::TIMESTAMP)), id);
+CREATE INDEX idx_tickets_user_created ON tickets(user_id, created_at, id);
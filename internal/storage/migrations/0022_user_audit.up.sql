@@ -0,0 +1,11 @@
+CREATE TABLE user_audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	actor_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+	source VARCHAR(20) NOT NULL,
+	field VARCHAR(50) NOT NULL,
+	old_value TEXT NULL,
+	new_value TEXT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_user_audit_log_user_id ON user_audit_log(user_id, created_at);
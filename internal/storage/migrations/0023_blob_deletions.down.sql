@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS trg_attachments_blob_deletion ON attachments;
+DROP FUNCTION IF EXISTS queue_attachment_blob_deletion();
+DROP TABLE IF EXISTS blob_deletions;
@@ -0,0 +1,17 @@
+CREATE TABLE blob_deletions (
+	storage_key VARCHAR(64) PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Attachment rows also go away through ON DELETE CASCADE from tickets,
+-- messages and users; the trigger catches every path.
+CREATE FUNCTION queue_attachment_blob_deletion() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO blob_deletions(storage_key) VALUES (OLD.storage_key) ON CONFLICT DO NOTHING;
+	RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_attachments_blob_deletion
+AFTER DELETE ON attachments
+FOR EACH ROW EXECUTE FUNCTION queue_attachment_blob_deletion();
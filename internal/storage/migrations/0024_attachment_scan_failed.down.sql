@@ -0,0 +1,5 @@
+UPDATE attachments SET scan_status = 'pending', scan_started_at = NULL, scanned_at = NULL WHERE scan_status = 'failed';
+
+ALTER TABLE attachments DROP CONSTRAINT IF EXISTS attachments_scan_status_check;
+ALTER TABLE attachments ADD CONSTRAINT attachments_scan_status_check
+	CHECK (scan_status IN ('pending', 'clean', 'infected'));
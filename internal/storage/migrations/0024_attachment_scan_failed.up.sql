@@ -0,0 +1,3 @@
+ALTER TABLE attachments DROP CONSTRAINT IF EXISTS attachments_scan_status_check;
+ALTER TABLE attachments ADD CONSTRAINT attachments_scan_status_check
+	CHECK (scan_status IN ('pending', 'clean', 'infected', 'failed'));
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrResetTokenInvalid = errors.New("reset token invalid or expired")
+
+type PasswordResetsRepo struct {
+	db *sql.DB
+}
+
+func NewPasswordResetsRepo(db *sql.DB) *PasswordResetsRepo {
+	return &PasswordResetsRepo{db: db}
+}
+
+func (r *PasswordResetsRepo) LogRequest(ctx context.Context, userID *int64, ip string) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO password_reset_requests(user_id, ip)
+VALUES ($1, $2);
+`, userID, ip)
+	return err
+}
+
+func (r *PasswordResetsRepo) CountRequestsByUser(ctx context.Context, userID int64, since time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM password_reset_requests
+WHERE user_id = $1 AND created_at >= $2;
+`, userID, since).Scan(&n)
+	return n, err
+}
+
+func (r *PasswordResetsRepo) CountRequestsByIP(ctx context.Context, ip string, since time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM password_reset_requests
+WHERE ip = $1 AND created_at >= $2;
+`, ip, since).Scan(&n)
+	return n, err
+}
+
+func (r *PasswordResetsRepo) CreateToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO password_reset_tokens(user_id, token_hash, expires_at)
+VALUES ($1, $2, $3);
+`, userID, tokenHash, expiresAt)
+	return err
+}
+
+// Consume redeems a reset token, sets the new password, lifts any login
+// lockout, burns every other outstanding token of the user and revokes all
+// of their sessions.
+func (r *PasswordResetsRepo) Consume(ctx context.Context, tokenHash, passHash string) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		id     int64
+		userID int64
+	)
+	err = tx.QueryRowContext(ctx, `
+SELECT id, user_id
+FROM password_reset_tokens
+WHERE token_hash = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+FOR UPDATE;
+`, tokenHash).Scan(&id, &userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrResetTokenInvalid
+		}
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+`, userID); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users
+SET password_hash = $1,
+    must_change_password = FALSE,
+    failed_logins = 0,
+    locked_until = NULL,
+    updated_at = NOW()
+WHERE id = $2;
+`, passHash, userID); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+`, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -36,3 +36,7 @@ func New(ctx context.Context, dsn string) (*Storage, error) {
 func (s *Storage) Close() error {
 	return s.DB.Close()
 }
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionRevoked = errors.New("session revoked or expired")
+	ErrRefreshReuse   = errors.New("refresh token reuse detected")
+)
+
+type CreateSessionParams struct {
+	JTI       string
+	FamilyID  string
+	UserID    int64
+	ExpiresAt time.Time
+	UserAgent string
+	IP        string
+}
+
+type Session struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt"`
+	ExpiresAt  string `json:"expiresAt"`
+	Current    bool   `json:"current"`
+}
+
+type SessionsRepo struct {
+	db *sql.DB
+}
+
+func NewSessionsRepo(db *sql.DB) *SessionsRepo {
+	return &SessionsRepo{db: db}
+}
+
+func (r *SessionsRepo) Create(ctx context.Context, p CreateSessionParams) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO sessions(jti, family_id, user_id, expires_at, user_agent, ip)
+VALUES ($1, $2, $3, $4, $5, $6);
+`, p.JTI, p.FamilyID, p.UserID, p.ExpiresAt, p.UserAgent, p.IP)
+	return err
+}
+
+// Rotate consumes the refresh token oldJTI and registers next in the same family.
+// Presenting an already rotated token revokes the whole family.
+func (r *SessionsRepo) Rotate(ctx context.Context, oldJTI string, next CreateSessionParams) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		familyID  string
+		userID    int64
+		expired   bool
+		rotatedAt sql.NullTime
+		revokedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `
+SELECT family_id, user_id, expires_at <= NOW(), rotated_at, revoked_at
+FROM sessions
+WHERE jti = $1
+FOR UPDATE;
+`, oldJTI).Scan(&familyID, &userID, &expired, &rotatedAt, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrSessionRevoked
+		}
+		return 0, err
+	}
+
+	if revokedAt.Valid || expired {
+		return 0, ErrSessionRevoked
+	}
+
+	if rotatedAt.Valid {
+		if _, err := tx.ExecContext(ctx, `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+`, familyID); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+		return 0, ErrRefreshReuse
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET rotated_at = NOW() WHERE jti = $1;`, oldJTI); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO sessions(jti, family_id, user_id, expires_at, user_agent, ip)
+VALUES ($1, $2, $3, $4, $5, $6);
+`, next.JTI, familyID, userID, next.ExpiresAt, next.UserAgent, next.IP); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+func (r *SessionsRepo) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+`, familyID)
+	return err
+}
+
+func (r *SessionsRepo) ListActive(ctx context.Context, userID int64) ([]Session, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT
+  cur.family_id,
+  COALESCE(cur.user_agent, ''),
+  COALESCE(cur.ip, ''),
+  f.created_at,
+  cur.created_at,
+  cur.expires_at
+FROM sessions cur
+JOIN (
+  SELECT family_id, MIN(created_at) AS created_at
+  FROM sessions
+  WHERE user_id = $1
+  GROUP BY family_id
+) f ON f.family_id = cur.family_id
+WHERE cur.user_id = $1
+  AND cur.rotated_at IS NULL
+  AND cur.revoked_at IS NULL
+  AND cur.expires_at > NOW()
+ORDER BY cur.created_at DESC;
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Session
+	for rows.Next() {
+		var (
+			s                          Session
+			created, lastUsed, expires time.Time
+		)
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &created, &lastUsed, &expires); err != nil {
+			return nil, err
+		}
+
+		s.CreatedAt = created.Format("15:04 02.01.2006")
+		s.LastUsedAt = lastUsed.Format("15:04 02.01.2006")
+		s.ExpiresAt = expires.Format("15:04 02.01.2006")
+
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func (r *SessionsRepo) RevokeUserFamily(ctx context.Context, userID int64, familyID string) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL;
+`, userID, familyID)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *SessionsRepo) RevokeAllForUser(ctx context.Context, userID int64, exceptFamilyID string) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL;
+`, userID, exceptFamilyID)
+	return err
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrAttachmentQuota = errors.New("ticket attachment quota exceeded")
+
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	// ScanFailed is final: clamd will never accept the file, e.g. it is over
+	// StreamMaxLength.
+	ScanFailed = "failed"
+)
+
+// Attachment is visible wherever its message is; ticket-level attachments
+// are public.
+type Attachment struct {
+	ID          int64  `json:"id"`
+	TicketID    int64  `json:"ticketId"`
+	MessageID   *int64 `json:"messageId,omitempty"`
+	UploaderID  int64  `json:"uploaderId"`
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Visibility  string `json:"visibility"`
+	ScanStatus  string `json:"scanStatus"`
+	CreatedAt   string `json:"createdAt"`
+	URL         string `json:"url"`
+	StorageKey  string `json:"-"`
+}
+
+// NewAttachment describes a blob that has already been stored under
+// StorageKey.
+type NewAttachment struct {
+	FileName    string
+	ContentType string
+	Size        int64
+	StorageKey  string
+	// ScanStatus is pending unless scanning is switched off.
+	ScanStatus string
+}
+
+const attachmentSelect = `
+SELECT
+  a.id,
+  a.ticket_id,
+  a.message_id,
+  COALESCE(a.uploader_id, 0),
+  a.file_name,
+  a.content_type,
+  a.size,
+  COALESCE(m.visibility, 'public'),
+  a.scan_status,
+  a.created_at,
+  a.storage_key
+FROM attachments a
+LEFT JOIN ticket_messages m ON m.id = a.message_id
+`
+
+func scanAttachment(s rowScanner) (Attachment, error) {
+	var (
+		a       Attachment
+		msgID   sql.NullInt64
+		created time.Time
+	)
+	if err := s.Scan(&a.ID, &a.TicketID, &msgID, &a.UploaderID, &a.FileName, &a.ContentType, &a.Size, &a.Visibility, &a.ScanStatus, &created, &a.StorageKey); err != nil {
+		return Attachment{}, err
+	}
+	if msgID.Valid {
+		a.MessageID = &msgID.Int64
+	}
+	a.CreatedAt = created.Format("15:04 02.01.2006")
+	return a, nil
+}
+
+func (r *TicketsRepo) ListAttachments(ctx context.Context, ticketID int64, withInternal bool) ([]Attachment, error) {
+	rows, err := r.db.QueryContext(ctx, attachmentSelect+`
+WHERE a.ticket_id = $1 AND ($2 OR COALESCE(m.visibility, 'public') = 'public')
+ORDER BY a.created_at ASC, a.id ASC;
+`, ticketID, withInternal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (r *TicketsRepo) GetAttachment(ctx context.Context, ticketID, id int64, withInternal bool) (Attachment, error) {
+	return scanAttachment(r.db.QueryRowContext(ctx, attachmentSelect+`
+WHERE a.id = $1 AND a.ticket_id = $2 AND ($3 OR COALESCE(m.visibility, 'public') = 'public');
+`, id, ticketID, withInternal))
+}
+
+// insertAttachments records stored blobs against a ticket, or one of its
+// messages when messageID is set, keeping the ticket within quota bytes.
+func insertAttachments(ctx context.Context, tx *sql.Tx, ticketID int64, messageID *int64, uploaderID int64, files []NewAttachment, quota int64) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	// The ticket row lock serialises concurrent uploads against the quota.
+	var used int64
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM tickets WHERE id = $1 FOR UPDATE;`, ticketID); err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(size), 0) FROM attachments WHERE ticket_id = $1;
+`, ticketID).Scan(&used); err != nil {
+		return err
+	}
+	for _, f := range files {
+		used += f.Size
+	}
+	if quota > 0 && used > quota {
+		return ErrAttachmentQuota
+	}
+
+	for _, f := range files {
+		status := f.ScanStatus
+		if status == "" {
+			status = ScanPending
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO attachments(ticket_id, message_id, uploader_id, file_name, content_type, size, storage_key, scan_status)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+`, ticketID, messageID, uploaderID, f.FileName, f.ContentType, f.Size, f.StorageKey, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimPendingScans hands out up to limit unscanned attachments. A claim
+// that is not settled within retryAfter is handed out again, so a crashed
+// worker or an unreachable clamd only delays the scan.
+func (r *TicketsRepo) ClaimPendingScans(ctx context.Context, limit int, retryAfter time.Duration) ([]Attachment, error) {
+	rows, err := r.db.QueryContext(ctx, `
+WITH claimed AS (
+  UPDATE attachments
+  SET scan_started_at = NOW()
+  WHERE id IN (
+    SELECT id FROM attachments
+    WHERE scan_status = 'pending'
+      AND (scan_started_at IS NULL OR scan_started_at < NOW() - make_interval(secs => $2))
+    ORDER BY id
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+  )
+  RETURNING id
+)`+attachmentSelect+`
+WHERE a.id IN (SELECT id FROM claimed)
+ORDER BY a.id;
+`, limit, retryAfter.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (r *TicketsRepo) MarkAttachmentClean(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE attachments
+SET scan_status = 'clean', scanned_at = NOW()
+WHERE id = $1 AND scan_status = 'pending';
+`, id)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+// MarkAttachmentFailed gives up on scanning an attachment and leaves an
+// internal note on the ticket, like MarkAttachmentInfected.
+func (r *TicketsRepo) MarkAttachmentFailed(ctx context.Context, id int64, note string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var ticketID int64
+	err = tx.QueryRowContext(ctx, `
+UPDATE attachments
+SET scan_status = 'failed', scanned_at = NOW()
+WHERE id = $1 AND scan_status = 'pending'
+RETURNING ticket_id;
+`, id).Scan(&ticketID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO ticket_messages(ticket_id, author_id, message, visibility)
+VALUES ($1, NULL, $2, 'internal');
+`, ticketID, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InfectedAttachment is what the uploader is told about a quarantined file.
+type InfectedAttachment struct {
+	TicketID      int64
+	TicketNumber  string
+	FileName      string
+	UploaderEmail string
+}
+
+// MarkAttachmentInfected records the signature and leaves an internal note
+// on the ticket. The note has no author: it comes from the scanner.
+func (r *TicketsRepo) MarkAttachmentInfected(ctx context.Context, id int64, signature, note string) (InfectedAttachment, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return InfectedAttachment{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		out   InfectedAttachment
+		email sql.NullString
+	)
+	err = tx.QueryRowContext(ctx, `
+UPDATE attachments a
+SET scan_status = 'infected', scan_signature = $2, scanned_at = NOW()
+FROM tickets t
+WHERE a.id = $1 AND a.scan_status = 'pending' AND t.id = a.ticket_id
+RETURNING a.ticket_id, t.ticket_number, a.file_name, (SELECT email FROM users WHERE id = a.uploader_id);
+`, id, signature).Scan(&out.TicketID, &out.TicketNumber, &out.FileName, &email)
+	if err != nil {
+		return InfectedAttachment{}, err
+	}
+	out.UploaderEmail = email.String
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO ticket_messages(ticket_id, author_id, message, visibility)
+VALUES ($1, NULL, $2, 'internal');
+`, out.TicketID, note); err != nil {
+		return InfectedAttachment{}, err
+	}
+	return out, tx.Commit()
+}
+
+// PendingBlobDeletions lists storage keys whose attachment rows were deleted,
+// usually by a ticket or user cascade, and whose blobs still have to go.
+func (r *TicketsRepo) PendingBlobDeletions(ctx context.Context, limit int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT storage_key FROM blob_deletions
+ORDER BY created_at
+LIMIT $1;
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		out = append(out, key)
+	}
+	return out, rows.Err()
+}
+
+func (r *TicketsRepo) ForgetBlobDeletion(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM blob_deletions WHERE storage_key = $1;`, key)
+	return err
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	TicketOpen       = "open"
+	TicketInProgress = "in_progress"
+	TicketResolved   = "resolved"
+	TicketClosed     = "closed"
+	TicketReopened   = "reopened"
+)
+
+var ticketTransitions = map[string][]string{
+	TicketOpen:       {TicketInProgress, TicketResolved, TicketClosed},
+	TicketInProgress: {TicketResolved, TicketClosed},
+	TicketResolved:   {TicketClosed, TicketReopened},
+	TicketClosed:     {TicketReopened},
+	TicketReopened:   {TicketInProgress, TicketResolved, TicketClosed},
+}
+
+var (
+	ErrTicketFinished      = errors.New("ticket is resolved or closed, reopen it to reply")
+	ErrReopenWindowExpired = errors.New("ticket can no longer be reopened")
+)
+
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move ticket from %s to %s", e.From, e.To)
+}
+
+func ValidTicketStatus(s string) bool {
+	_, ok := ticketTransitions[s]
+	return ok
+}
+
+func CanTransitionTicket(from, to string) bool {
+	return slices.Contains(ticketTransitions[from], to)
+}
+
+// TicketTransitions lists the statuses a ticket in status from may move to.
+func TicketTransitions(from string) []string {
+	return slices.Clone(ticketTransitions[from])
+}
+
+// Transition moves a ticket to status to. Starting work on an unassigned
+// ticket assigns it to actorID.
+func (r *TicketsRepo) Transition(ctx context.Context, id int64, to string, actorID int64) (TicketDetail, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TicketDetail{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	from, err := lockTicketStatus(ctx, tx, id)
+	if err != nil {
+		return TicketDetail{}, err
+	}
+	if err := setTicketStatus(ctx, tx, id, from, to); err != nil {
+		return TicketDetail{}, err
+	}
+	if to == TicketInProgress {
+		if _, err := tx.ExecContext(ctx, `UPDATE tickets SET taken_by = COALESCE(taken_by, $1) WHERE id = $2;`, actorID, id); err != nil {
+			return TicketDetail{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TicketDetail{}, err
+	}
+	return r.GetTicket(ctx, id)
+}
+
+func lockTicketStatus(ctx context.Context, tx *sql.Tx, id int64) (string, error) {
+	var status string
+	err := tx.QueryRowContext(ctx, `SELECT status FROM tickets WHERE id = $1 FOR UPDATE;`, id).Scan(&status)
+	return status, err
+}
+
+// startTicketWork moves a ticket that support picks up into in_progress,
+// leaving tickets already in progress alone.
+func startTicketWork(ctx context.Context, tx *sql.Tx, id int64) error {
+	from, err := lockTicketStatus(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if from == TicketInProgress {
+		return nil
+	}
+	return setTicketStatus(ctx, tx, id, from, TicketInProgress)
+}
+
+// setTicketStatus applies a checked transition. resolved_at is set while a
+// ticket is resolved or closed after resolution, closed_at only while it is
+// closed; reopening clears both and puts the ticket back in the queue.
+func setTicketStatus(ctx context.Context, tx *sql.Tx, id int64, from, to string) error {
+	if !CanTransitionTicket(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+UPDATE tickets
+SET status = $1,
+    resolved_at = CASE
+      WHEN $1 = 'resolved' THEN NOW()
+      WHEN $1 = 'closed' THEN resolved_at
+    END,
+    closed_at = CASE WHEN $1 = 'closed' THEN NOW() END,
+    awaiting_support = awaiting_support OR $1 = 'reopened',
+    updated_at = NOW()
+WHERE id = $2;
+`, to, id)
+	return err
+}
+
+// AddReporterMessage posts a follow-up from the ticket's reporter and hands
+// the ticket back to support.
+func (r *TicketsRepo) AddReporterMessage(ctx context.Context, p AddMessageParams) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status, _, err := lockReporterTicket(ctx, tx, p.TicketID, p.AuthorID, 0)
+	if err != nil {
+		return err
+	}
+	if status == TicketResolved || status == TicketClosed {
+		return ErrTicketFinished
+	}
+
+	p.Visibility = MessagePublic
+	if err := insertMessage(ctx, tx, p); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE tickets
+SET awaiting_support = TRUE,
+    updated_at = NOW()
+WHERE id = $1;
+`, p.TicketID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConfirmResolution lets the reporter accept a resolved ticket, closing it.
+func (r *TicketsRepo) ConfirmResolution(ctx context.Context, ticketID, userID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status, _, err := lockReporterTicket(ctx, tx, ticketID, userID, 0)
+	if err != nil {
+		return err
+	}
+	if status != TicketResolved {
+		return &TransitionError{From: status, To: TicketClosed}
+	}
+	if err := setTicketStatus(ctx, tx, ticketID, status, TicketClosed); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reopen returns a resolved or closed ticket to support with the reporter's
+// reason, provided it was finished no longer than window ago.
+func (r *TicketsRepo) Reopen(ctx context.Context, ticketID, userID int64, reason string, window time.Duration) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status, inWindow, err := lockReporterTicket(ctx, tx, ticketID, userID, window)
+	if err != nil {
+		return err
+	}
+	if err := setTicketStatus(ctx, tx, ticketID, status, TicketReopened); err != nil {
+		return err
+	}
+	if !inWindow {
+		return ErrReopenWindowExpired
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO ticket_messages(ticket_id, author_id, message)
+VALUES ($1, $2, $3);
+`, ticketID, userID, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockReporterTicket locks a ticket raised by userID and reports whether it
+// was resolved or closed within window.
+func lockReporterTicket(ctx context.Context, tx *sql.Tx, ticketID, userID int64, window time.Duration) (string, bool, error) {
+	var (
+		status   string
+		inWindow bool
+	)
+	err := tx.QueryRowContext(ctx, `
+SELECT status, COALESCE(COALESCE(closed_at, resolved_at) >= NOW() - make_interval(secs => $3), FALSE)
+FROM tickets
+WHERE id = $1 AND user_id = $2
+FOR UPDATE;
+`, ticketID, userID, window.Seconds()).Scan(&status, &inWindow)
+	return status, inWindow, err
+}
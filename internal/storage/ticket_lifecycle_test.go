@@ -0,0 +1,34 @@
+package storage
+
+import "testing"
+
+func TestCanTransitionTicket(t *testing.T) {
+	statuses := []string{TicketOpen, TicketInProgress, TicketResolved, TicketClosed, TicketReopened}
+	allowed := map[[2]string]bool{
+		{TicketOpen, TicketInProgress}:     true,
+		{TicketOpen, TicketResolved}:       true,
+		{TicketOpen, TicketClosed}:         true,
+		{TicketInProgress, TicketResolved}: true,
+		{TicketInProgress, TicketClosed}:   true,
+		{TicketResolved, TicketClosed}:     true,
+		{TicketResolved, TicketReopened}:   true,
+		{TicketClosed, TicketReopened}:     true,
+		{TicketReopened, TicketInProgress}: true,
+		{TicketReopened, TicketResolved}:   true,
+		{TicketReopened, TicketClosed}:     true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := CanTransitionTicket(from, to); got != want {
+				t.Errorf("CanTransitionTicket(%q, %q) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	for _, tt := range [][2]string{{"", TicketOpen}, {TicketOpen, ""}, {"deleted", TicketClosed}, {TicketOpen, "open "}} {
+		if CanTransitionTicket(tt[0], tt[1]) {
+			t.Errorf("CanTransitionTicket(%q, %q) = true for an unknown status", tt[0], tt[1])
+		}
+	}
+}
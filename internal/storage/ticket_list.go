@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// ticketSortKeys maps a sort name to a never-NULL expression and the type
+// its text form is cast back to when it comes back in a cursor.
+var ticketSortKeys = map[string]struct{ expr, typ string }{
+	"created":  {"t.created_at", "timestamp"},
+	"updated":  {"t.updated_at", "timestamp"},
+	"sla":      {"COALESCE(t.sla_due_at, 'infinity'::timestamp)", "timestamp"},
+	"priority": {"CASE t.priority WHEN 'high' THEN 3 WHEN 'medium' THEN 2 ELSE 1 END", "int"},
+	"status":   {"CASE t.status WHEN 'open' THEN 1 WHEN 'reopened' THEN 2 WHEN 'in_progress' THEN 3 WHEN 'resolved' THEN 4 ELSE 5 END", "int"},
+}
+
+func ValidTicketSort(s string) bool {
+	_, ok := ticketSortKeys[s]
+	return ok
+}
+
+// TicketCursor marks the last row of a page. It is only valid for the sort
+// and direction it was issued with.
+type TicketCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d"`
+	Key  string `json:"k"`
+	ID   int64  `json:"i"`
+}
+
+func (c TicketCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeTicketCursor also checks that the key parses as its sort type, so a
+// tampered cursor never reaches the ::timestamp or ::int cast.
+func DecodeTicketCursor(s string) (TicketCursor, error) {
+	var c TicketCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || !ValidTicketSort(c.Sort) || c.ID <= 0 {
+		return TicketCursor{}, ErrBadCursor
+	}
+	if !validCursorKey(ticketSortKeys[c.Sort].typ, c.Key) {
+		return TicketCursor{}, ErrBadCursor
+	}
+	return c, nil
+}
+
+// validCursorKey accepts what Postgres prints for the sort key's ::text;
+// lib/pq always runs sessions with DateStyle ISO.
+func validCursorKey(typ, key string) bool {
+	switch typ {
+	case "int":
+		_, err := strconv.ParseInt(key, 10, 32)
+		return err == nil
+	case "timestamp":
+		if key == "infinity" {
+			return true
+		}
+		_, err := time.Parse("2006-01-02 15:04:05.999999", key)
+		return err == nil
+	}
+	return false
+}
+
+type TicketPage struct {
+	Items      []TicketListItem
+	NextCursor string
+	// Total is set only when requested; it ignores the cursor.
+	Total *int
+}
+
+func (r *TicketsRepo) ListTickets(ctx context.Context, p ListTicketsParams) (TicketPage, error) {
+	where := []string{}
+	args := []any{}
+	n := 1
+
+	if p.Tab != "" && p.Tab != "all" {
+		if p.Tab == "new" {
+			where = append(where, fmt.Sprintf("t.status = $%d", n))
+			args = append(args, "open")
+			n++
+		} else if p.Tab == "queue" {
+			where = append(where, "t.awaiting_support AND t.status IN ('open', 'in_progress', 'reopened')")
+		} else {
+			where = append(where, fmt.Sprintf("t.status = $%d", n))
+			args = append(args, p.Tab)
+			n++
+		}
+	}
+
+	if q := strings.TrimSpace(p.Q); q != "" {
+		where = append(where, fmt.Sprintf("(t.ticket_number ILIKE $%d OR t.title ILIKE $%d)", n, n))
+		args = append(args, "%"+q+"%")
+		n++
+	}
+
+	if p.DeptOf != 0 {
+		where = append(where, fmt.Sprintf("u.dept_id = (SELECT dept_id FROM users WHERE id = $%d)", n))
+		args = append(args, p.DeptOf)
+		n++
+	}
+
+	return r.listTickets(ctx, where, args, p.Page)
+}
+
+func (r *TicketsRepo) ListMyTickets(ctx context.Context, userID int64, page TicketPageParams) (TicketPage, error) {
+	return r.listTickets(ctx, []string{"t.user_id = $1"}, []any{userID}, page)
+}
+
+// listTickets pages with a keyset on (sort key, id). With sort=created the
+// key never changes, so rows inserted between requests do not shift later
+// pages; with the other sorts a ticket whose key changes between requests
+// can be skipped or shown twice.
+func (r *TicketsRepo) listTickets(ctx context.Context, where []string, args []any, p TicketPageParams) (TicketPage, error) {
+	key, ok := ticketSortKeys[p.Sort]
+	if !ok {
+		key = ticketSortKeys["created"]
+	}
+	dir, cmp := "ASC", ">"
+	if p.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	var page TicketPage
+	if p.WithTotal {
+		w := ""
+		if len(where) > 0 {
+			w = "WHERE " + strings.Join(where, " AND ")
+		}
+		var total int
+		if err := r.db.QueryRowContext(ctx, `
+SELECT COUNT(*)
+FROM tickets t
+JOIN users u ON u.id = t.user_id
+`+w+`;`, args...).Scan(&total); err != nil {
+			return TicketPage{}, err
+		}
+		page.Total = &total
+	}
+
+	n := len(args) + 1
+	if p.Cursor != nil {
+		where = append(where, fmt.Sprintf("(%s, t.id) %s ($%d::%s, $%d)", key.expr, cmp, n, key.typ, n+1))
+		args = append(args, p.Cursor.Key, p.Cursor.ID)
+		n += 2
+	}
+	w := ""
+	if len(where) > 0 {
+		w = "WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, p.Limit+1)
+
+	rows, err := r.db.QueryContext(ctx, `
+SELECT
+  t.id,
+  t.ticket_number,
+  t.title,
+  t.created_at,
+  t.updated_at,
+  t.sla_due_at,
+  t.priority,
+  t.status,
+  u2.first_name,
+  u2.last_name,
+  (`+key.expr+`)::text
+FROM tickets t
+JOIN users u ON u.id = t.user_id
+LEFT JOIN users u2 ON u2.id = t.taken_by
+`+w+`
+ORDER BY `+key.expr+` `+dir+`, t.id `+dir+`
+LIMIT $`+fmt.Sprint(n)+`;
+`, args...)
+	if err != nil {
+		return TicketPage{}, err
+	}
+	defer rows.Close()
+
+	var last TicketCursor
+	for rows.Next() {
+		var (
+			it      TicketListItem
+			created time.Time
+			updated time.Time
+			slaDue  sql.NullTime
+			fn, ln  sql.NullString
+			sortKey string
+		)
+		if err := rows.Scan(&it.ID, &it.TicketNumber, &it.Title, &created, &updated, &slaDue, &it.Priority, &it.Status, &fn, &ln, &sortKey); err != nil {
+			return TicketPage{}, err
+		}
+
+		if len(page.Items) == p.Limit {
+			page.NextCursor = last.Encode()
+			break
+		}
+
+		it.CreatedAt = created.Format("15:04 02.01.2006")
+		it.UpdatedAt = updated.Format("15:04 02.01.2006")
+		if slaDue.Valid {
+			s := slaDue.Time.Format("15:04 02.01.2006")
+			it.SLADueAt = &s
+		}
+		if fn.Valid || ln.Valid {
+			s := strings.TrimSpace(strings.TrimSpace(fn.String) + " " + strings.TrimSpace(ln.String))
+			if s != "" {
+				it.AssigneeName = &s
+			}
+		}
+
+		page.Items = append(page.Items, it)
+		last = TicketCursor{Sort: p.Sort, Desc: p.Desc, Key: sortKey, ID: it.ID}
+	}
+	return page, rows.Err()
+}
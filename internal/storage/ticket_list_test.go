@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestDecodeTicketCursor(t *testing.T) {
+	valid := []TicketCursor{
+		{Sort: "created", Key: "2026-03-05 10:00:00.123456", ID: 1},
+		{Sort: "updated", Desc: true, Key: "2026-03-05 10:00:00", ID: 42},
+		{Sort: "sla", Key: "infinity", ID: 7},
+		{Sort: "priority", Key: "3", ID: 9},
+		{Sort: "status", Desc: true, Key: "-1", ID: 9},
+	}
+	for _, c := range valid {
+		got, err := DecodeTicketCursor(c.Encode())
+		if err != nil || got != c {
+			t.Errorf("round trip of %+v = %+v, %v", c, got, err)
+		}
+	}
+
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tampered := []string{
+		"",
+		"not base64!",
+		raw("not json"),
+		raw(`{"s":"title","k":"a","i":1}`),
+		raw(`{"s":"created","k":"2026-03-05 10:00:00","i":0}`),
+		raw(`{"s":"created","k":"2026-03-05 10:00:00","i":-5}`),
+		(TicketCursor{Sort: "created", Key: "yesterday", ID: 1}).Encode(),
+		(TicketCursor{Sort: "created", Key: "2026-03-05T10:00:00Z", ID: 1}).Encode(),
+		(TicketCursor{Sort: "created", Key: "2026-03-05 10:00:00'; DROP TABLE tickets; --", ID: 1}).Encode(),
+		(TicketCursor{Sort: "updated", Key: "-infinity", ID: 1}).Encode(),
+		(TicketCursor{Sort: "priority", Key: "1.5", ID: 1}).Encode(),
+		(TicketCursor{Sort: "priority", Key: "99999999999", ID: 1}).Encode(),
+		(TicketCursor{Sort: "status", Key: "", ID: 1}).Encode(),
+		(TicketCursor{Sort: "status", Key: "1 OR 1=1", ID: 1}).Encode(),
+	}
+	for _, s := range tampered {
+		if c, err := DecodeTicketCursor(s); !errors.Is(err, ErrBadCursor) {
+			t.Errorf("DecodeTicketCursor(%q) = %+v, %v; want ErrBadCursor", s, c, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxTicketNumberLen = 50
+
+var numberPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// TicketNumberTemplate renders ticket numbers such as KOM-{YYYY}-{seq:06}.
+// Placeholders: {YYYY}, {YY}, {MM}, {DEPT} (the reporter's department
+// prefix, or DefaultPrefix) and exactly one {seq} or {seq:0N}.
+type TicketNumberTemplate struct {
+	raw           string
+	width         int
+	defaultPrefix string
+}
+
+func ParseTicketNumberTemplate(raw, defaultPrefix string) (TicketNumberTemplate, error) {
+	t := TicketNumberTemplate{raw: raw, defaultPrefix: defaultPrefix}
+
+	seqs := 0
+	for _, ph := range numberPlaceholder.FindAllString(raw, -1) {
+		switch name := ph[1 : len(ph)-1]; {
+		case name == "YYYY", name == "YY", name == "MM", name == "DEPT":
+		case name == "seq":
+			seqs++
+		case strings.HasPrefix(name, "seq:0"):
+			w, err := strconv.Atoi(name[len("seq:0"):])
+			if err != nil || w < 1 || w > 18 {
+				return t, fmt.Errorf("ticket number template: bad width in %s", ph)
+			}
+			t.width = w
+			seqs++
+		default:
+			return t, fmt.Errorf("ticket number template: unknown placeholder %s", ph)
+		}
+	}
+	if seqs != 1 {
+		return t, fmt.Errorf("ticket number template must contain exactly one {seq}")
+	}
+	return t, nil
+}
+
+func (t TicketNumberTemplate) render(now time.Time, dept, seq string) string {
+	if dept == "" {
+		dept = t.defaultPrefix
+	}
+	return numberPlaceholder.ReplaceAllStringFunc(t.raw, func(ph string) string {
+		switch ph {
+		case "{YYYY}":
+			return now.Format("2006")
+		case "{YY}":
+			return now.Format("06")
+		case "{MM}":
+			return now.Format("01")
+		case "{DEPT}":
+			return dept
+		default:
+			return seq
+		}
+	})
+}
+
+// next allocates the next number for a ticket raised by userID.
+// The counter row stays locked until tx ends, so concurrent tickets queue up
+// and a rolled back ticket gives its number back.
+func (t TicketNumberTemplate) next(ctx context.Context, tx *sql.Tx, userID int64) (string, error) {
+	var dept string
+	if err := tx.QueryRowContext(ctx, `
+SELECT COALESCE(d.ticket_prefix, '')
+FROM users u
+LEFT JOIN depts d ON d.id = u.dept_id
+WHERE u.id = $1;
+`, userID).Scan(&dept); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	scope := t.render(now, dept, "{seq}")
+	if len(scope) > 100 {
+		return "", fmt.Errorf("ticket number scope %q too long", scope)
+	}
+
+	var seq int64
+	if err := tx.QueryRowContext(ctx, `
+INSERT INTO ticket_counters (scope, last_value)
+VALUES ($1, 1)
+ON CONFLICT (scope) DO UPDATE
+SET last_value = ticket_counters.last_value + 1,
+    updated_at = NOW()
+RETURNING last_value;
+`, scope).Scan(&seq); err != nil {
+		return "", err
+	}
+
+	n := strconv.FormatInt(seq, 10)
+	if len(n) < t.width {
+		n = strings.Repeat("0", t.width-len(n)) + n
+	}
+	number := t.render(now, dept, n)
+	if len(number) > maxTicketNumberLen {
+		return "", fmt.Errorf("ticket number %q too long", number)
+	}
+	return number, nil
+}
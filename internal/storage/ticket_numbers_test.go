@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTicketNumberTemplate(t *testing.T) {
+	tests := []struct {
+		raw     string
+		width   int
+		wantErr bool
+	}{
+		{"KOM-{seq}", 0, false},
+		{"KOM-{YYYY}-{seq:06}", 6, false},
+		{"{DEPT}/{YY}{MM}/{seq:01}", 1, false},
+		{"{seq:018}", 18, false},
+		{"KOM-{YYYY}", 0, true},
+		{"{seq}-{seq:04}", 0, true},
+		{"{seq:019}", 0, true},
+		{"{seq:00}", 0, true},
+		{"{seq:0x}", 0, true},
+		{"{seq:4}", 0, true},
+		{"{DD}-{seq}", 0, true},
+		{"{yyyy}-{seq}", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTicketNumberTemplate(tt.raw, "KOM")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTicketNumberTemplate(%q) err = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.width != tt.width {
+			t.Errorf("ParseTicketNumberTemplate(%q) width = %d, want %d", tt.raw, got.width, tt.width)
+		}
+	}
+}
+
+func TestTicketNumberTemplateRender(t *testing.T) {
+	now := time.Date(2026, time.March, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		raw, dept, seq string
+		want           string
+	}{
+		{"KOM-{YYYY}-{seq:06}", "", "000042", "KOM-2026-000042"},
+		{"{DEPT}-{YY}{MM}-{seq}", "IT", "7", "IT-2603-7"},
+		{"{DEPT}-{YY}{MM}-{seq}", "", "7", "HD-2603-7"},
+		{"{DEPT}/{DEPT}/{seq}", "ACC", "{seq}", "ACC/ACC/{seq}"},
+		{"{{seq}}", "", "1", "{1}"},
+	}
+	for _, tt := range tests {
+		tpl, err := ParseTicketNumberTemplate(tt.raw, "HD")
+		if err != nil {
+			t.Fatalf("ParseTicketNumberTemplate(%q): %v", tt.raw, err)
+		}
+		if got := tpl.render(now, tt.dept, tt.seq); got != tt.want {
+			t.Errorf("render(%q, dept %q, seq %q) = %q, want %q", tt.raw, tt.dept, tt.seq, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,22 @@
 package storage
 
+import "time"
+
 type ListTicketsParams struct {
 	Tab string
 	Q   string
+	// DeptOf limits the list to tickets raised in this user's department.
+	DeptOf int64
+	Page   TicketPageParams
+}
+
+type TicketPageParams struct {
+	// Sort is one of created, updated, priority, status and sla.
+	Sort      string
+	Desc      bool
+	Limit     int
+	Cursor    *TicketCursor
+	WithTotal bool
 }
 
 type TicketListItem struct {
@@ -10,6 +24,8 @@ type TicketListItem struct {
 	TicketNumber string  `json:"ticketNumber"`
 	Title        string  `json:"title"`
 	CreatedAt    string  `json:"createdAt"`
+	UpdatedAt    string  `json:"updatedAt"`
+	SLADueAt     *string `json:"slaDueAt,omitempty"`
 	Priority     string  `json:"priority"`
 	Status       string  `json:"status"`
 	AssigneeName *string `json:"assigneeName,omitempty"`
@@ -24,6 +40,7 @@ type TicketDetail struct {
 	Status       string  `json:"status"`
 	AssigneeID   *int64  `json:"assigneeId,omitempty"`
 	AssigneeName *string `json:"assigneeName,omitempty"`
+	// SupportReply is the latest public message from staff.
 	SupportReply string  `json:"supportReply"`
 	RepliedAt    *string `json:"repliedAt,omitempty"`
 	Topic        string  `json:"topic"`
@@ -31,25 +48,53 @@ type TicketDetail struct {
 	Dept         *string `json:"dept,omitempty"`
 	Phone        *string `json:"phone,omitempty"`
 	Message      string  `json:"message"`
+	// Transitions lists the statuses support may move the ticket to.
+	Transitions []string `json:"transitions,omitempty"`
 }
 
+const (
+	MessagePublic   = "public"
+	MessageInternal = "internal"
+)
+
 type AddMessageParams struct {
-	TicketID int64
-	AuthorID int64
-	Message  string
+	TicketID    int64
+	AuthorID    int64
+	Message     string
+	Visibility  string
+	Attachments []NewAttachment
+	// AttachmentQuota caps the total attachment bytes per ticket; 0 means
+	// no limit.
+	AttachmentQuota int64
 }
 
 type CreateTicketParams struct {
-	Title       string
-	Description string
-	Priority    string
-	UserID      int64
+	Title           string
+	Description     string
+	Priority        string
+	UserID          int64
+	Attachments     []NewAttachment
+	AttachmentQuota int64
+	// SLA is the time allowed to resolve the ticket; 0 leaves no due date.
+	SLA time.Duration
 }
 
 type TicketMessage struct {
-	ID        int64  `json:"id"`
-	AuthorID  int64  `json:"authorId"`
-	Author    string `json:"author"`
-	Message   string `json:"message"`
-	CreatedAt string `json:"createdAt"`
+	ID          int64        `json:"id"`
+	AuthorID    int64        `json:"authorId"`
+	Author      string       `json:"author"`
+	Message     string       `json:"message"`
+	Visibility  string       `json:"visibility"`
+	CreatedAt   string       `json:"createdAt"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+type TicketCounts struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"byStatus"`
+}
+
+type UserTicketStats struct {
+	Raised   TicketCounts `json:"raised"`
+	Assigned TicketCounts `json:"assigned"`
 }
@@ -3,107 +3,17 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"strings"
 	"time"
 )
 
 type TicketsRepo struct {
-	db *sql.DB
+	db      *sql.DB
+	numbers TicketNumberTemplate
 }
 
-func NewTicketsRepo(db *sql.DB) *TicketsRepo {
-	return &TicketsRepo{db: db}
-}
-
-func (r *TicketsRepo) ListTickets(ctx context.Context, p ListTicketsParams) ([]TicketListItem, error) {
-	where := []string{}
-	args := []any{}
-	n := 1
-
-	if p.Tab != "" && p.Tab != "all" {
-		if p.Tab == "new" {
-			where = append(where, fmt.Sprintf("t.status = $%d", n))
-			args = append(args, "open")
-			n++
-		} else {
-			where = append(where, fmt.Sprintf("t.status = $%d", n))
-			args = append(args, p.Tab)
-			n++
-		}
-	}
-
-	if q := strings.TrimSpace(p.Q); q != "" {
-		where = append(where, fmt.Sprintf("(t.ticket_number ILIKE $%d OR t.title ILIKE $%d)", n, n))
-		args = append(args, "%"+q+"%")
-		n++
-	}
-
-	w := ""
-	if len(where) > 0 {
-		w = "WHERE " + strings.Join(where, " AND ")
-	}
-
-	query := `
-SELECT
-  t.id,
-  t.ticket_number,
-  t.title,
-  t.created_at,
-  t.priority,
-  t.status,
-  u2.first_name,
-  u2.last_name
-FROM tickets t
-JOIN users u ON u.id = t.user_id
-LEFT JOIN users u2 ON u2.id = t.taken_by
-` + w + `
-ORDER BY t.created_at DESC
-LIMIT 200;
-`
-
-	rows, err := r.db.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var out []TicketListItem
-	for rows.Next() {
-		var (
-			id       int64
-			num      string
-			title    string
-			created  time.Time
-			priority string
-			status   string
-			fn, ln   sql.NullString
-		)
-
-		if err := rows.Scan(&id, &num, &title, &created, &priority, &status, &fn, &ln); err != nil {
-			return nil, err
-		}
-
-		var assigneeName *string
-		if fn.Valid || ln.Valid {
-			s := strings.TrimSpace(strings.TrimSpace(fn.String) + " " + strings.TrimSpace(ln.String))
-			if s != "" {
-				assigneeName = &s
-			}
-		}
-
-		out = append(out, TicketListItem{
-			ID:           id,
-			TicketNumber: num,
-			Title:        title,
-			CreatedAt:    created.Format("15:04 02.01.2006"),
-			Priority:     priority,
-			Status:       status,
-			AssigneeName: assigneeName,
-		})
-	}
-
-	return out, rows.Err()
+func NewTicketsRepo(db *sql.DB, numbers TicketNumberTemplate) *TicketsRepo {
+	return &TicketsRepo{db: db, numbers: numbers}
 }
 
 func (r *TicketsRepo) GetTicket(ctx context.Context, id int64) (TicketDetail, error) {
@@ -117,8 +27,8 @@ SELECT
   t.priority,
   t.status,
   t.taken_by,
-  t.support_reply,
-  t.replied_at,
+  r.message,
+  r.created_at,
   u.first_name,
   u.last_name,
   d.name,
@@ -129,6 +39,15 @@ FROM tickets t
 JOIN users u ON u.id = t.user_id
 LEFT JOIN depts d ON d.id = u.dept_id
 LEFT JOIN users u2 ON u2.id = t.taken_by
+LEFT JOIN LATERAL (
+  SELECT m.message, m.created_at
+  FROM ticket_messages m
+  WHERE m.ticket_id = t.id
+    AND m.visibility = 'public'
+    AND m.author_id IS DISTINCT FROM t.user_id
+  ORDER BY m.created_at DESC, m.id DESC
+  LIMIT 1
+) r ON TRUE
 WHERE t.id = $1
 LIMIT 1;
 `
@@ -209,124 +128,159 @@ LIMIT 1;
 		}
 	}
 
+	t.Transitions = TicketTransitions(t.Status)
 	return t, nil
 }
 
+func (r *TicketsRepo) InDeptOf(ctx context.Context, ticketID, userID int64) (bool, error) {
+	var in bool
+	err := r.db.QueryRowContext(ctx, `
+SELECT EXISTS (
+  SELECT 1
+  FROM tickets t
+  JOIN users u ON u.id = t.user_id
+  WHERE t.id = $1 AND u.dept_id = (SELECT dept_id FROM users WHERE id = $2)
+);
+`, ticketID, userID).Scan(&in)
+	return in, err
+}
+
 func (r *TicketsRepo) AssignTicket(ctx context.Context, id int64, assigneeID int64) (TicketDetail, error) {
-	_, err := r.db.ExecContext(ctx, `
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TicketDetail{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := startTicketWork(ctx, tx, id); err != nil {
+		return TicketDetail{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
 UPDATE tickets
 SET taken_by = $1,
-    status = CASE WHEN status = 'open' THEN 'in_progress' ELSE status END,
     updated_at = NOW()
 WHERE id = $2;
-`, assigneeID, id)
-	if err != nil {
+`, assigneeID, id); err != nil {
 		return TicketDetail{}, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return TicketDetail{}, err
+	}
 	return r.GetTicket(ctx, id)
 }
 
+// AddMessage posts a support message. A public one leaves the ticket waiting
+// for its reporter; an internal note does not change whose turn it is.
 func (r *TicketsRepo) AddMessage(ctx context.Context, p AddMessageParams) error {
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO ticket_messages(ticket_id, author_id, message)
-VALUES ($1, $2, $3);
-`, p.TicketID, p.AuthorID, p.Message)
-	return err
-}
+	if p.Visibility == "" {
+		p.Visibility = MessagePublic
+	}
 
-func (r *TicketsRepo) ListMyTickets(ctx context.Context, userID int64) ([]TicketListItem, error) {
-	rows, err := r.db.QueryContext(ctx, `
-SELECT
-  t.id,
-  t.ticket_number,
-  t.title,
-  t.created_at,
-  t.priority,
-  t.status,
-  u2.first_name,
-  u2.last_name
-FROM tickets t
-LEFT JOIN users u2 ON u2.id = t.taken_by
-WHERE t.user_id = $1
-ORDER BY t.created_at DESC
-LIMIT 200;
-`, userID)
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer func() { _ = tx.Rollback() }()
 
-	var out []TicketListItem
-	for rows.Next() {
-		var (
-			id       int64
-			num      string
-			title    string
-			created  time.Time
-			priority string
-			status   string
-			fn, ln   sql.NullString
-		)
-
-		if err := rows.Scan(&id, &num, &title, &created, &priority, &status, &fn, &ln); err != nil {
-			return nil, err
-		}
-
-		var assigneeName *string
-		if fn.Valid || ln.Valid {
-			s := strings.TrimSpace(strings.TrimSpace(fn.String) + " " + strings.TrimSpace(ln.String))
-			if s != "" {
-				assigneeName = &s
-			}
-		}
+	res, err := tx.ExecContext(ctx, `
+UPDATE tickets
+SET awaiting_support = awaiting_support AND $2 = 'internal',
+    updated_at = NOW()
+WHERE id = $1;
+`, p.TicketID, p.Visibility)
+	if err != nil {
+		return err
+	}
+	if err := affectedOrNoRows(res); err != nil {
+		return err
+	}
 
-		out = append(out, TicketListItem{
-			ID:           id,
-			TicketNumber: num,
-			Title:        title,
-			CreatedAt:    created.Format("15:04 02.01.2006"),
-			Priority:     priority,
-			Status:       status,
-			AssigneeName: assigneeName,
-		})
+	if err := insertMessage(ctx, tx, p); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
-	return out, rows.Err()
+func insertMessage(ctx context.Context, tx *sql.Tx, p AddMessageParams) error {
+	var id int64
+	if err := tx.QueryRowContext(ctx, `
+INSERT INTO ticket_messages(ticket_id, author_id, message, visibility)
+VALUES ($1, $2, $3, $4)
+RETURNING id;
+`, p.TicketID, p.AuthorID, p.Message, p.Visibility).Scan(&id); err != nil {
+		return err
+	}
+	return insertAttachments(ctx, tx, p.TicketID, &id, p.AuthorID, p.Attachments, p.AttachmentQuota)
 }
 
-func (r *TicketsRepo) CreateTicket(ctx context.Context, p CreateTicketParams) (TicketDetail, error) {
+// TicketIDByNumber resolves a ticket number; reporterID, when not 0, limits
+// the lookup to that user's tickets.
+func (r *TicketsRepo) TicketIDByNumber(ctx context.Context, number string, reporterID int64) (int64, error) {
 	var id int64
+	err := r.db.QueryRowContext(ctx, `
+SELECT id FROM tickets
+WHERE ticket_number = $1 AND ($2 = 0 OR user_id = $2);
+`, number, reporterID).Scan(&id)
+	return id, err
+}
 
+func (r *TicketsRepo) IsReporter(ctx context.Context, ticketID, userID int64) (bool, error) {
+	var ok bool
 	err := r.db.QueryRowContext(ctx, `
-INSERT INTO tickets(ticket_number, title, description, status, priority, user_id)
-VALUES (
-  LPAD(CAST((SELECT COALESCE(MAX(id),0)+1 FROM tickets) AS TEXT), 6, '0'),
-  $1, $2, 'open', $3, $4
-)
+SELECT EXISTS (SELECT 1 FROM tickets WHERE id = $1 AND user_id = $2);
+`, ticketID, userID).Scan(&ok)
+	return ok, err
+}
+
+func (r *TicketsRepo) CreateTicket(ctx context.Context, p CreateTicketParams) (TicketDetail, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TicketDetail{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	number, err := r.numbers.next(ctx, tx, p.UserID)
+	if err != nil {
+		return TicketDetail{}, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO tickets(ticket_number, title, description, status, priority, user_id, sla_due_at)
+VALUES ($1, $2, $3, 'open', $4, $5, CASE WHEN $6 > 0 THEN NOW() + make_interval(secs => $6) END)
 RETURNING id;
-`, p.Title, p.Description, p.Priority, p.UserID).Scan(&id)
+`, number, p.Title, p.Description, p.Priority, p.UserID, p.SLA.Seconds()).Scan(&id)
 	if err != nil {
 		return TicketDetail{}, err
 	}
+	if err := insertAttachments(ctx, tx, id, nil, p.UserID, p.Attachments, p.AttachmentQuota); err != nil {
+		return TicketDetail{}, err
+	}
 
+	if err := tx.Commit(); err != nil {
+		return TicketDetail{}, err
+	}
 	return r.GetTicket(ctx, id)
 }
 
-func (r *TicketsRepo) ListMessages(ctx context.Context, ticketID int64) ([]TicketMessage, error) {
+// ListMessages returns the ticket thread; internal notes are included only
+// for staff.
+func (r *TicketsRepo) ListMessages(ctx context.Context, ticketID int64, withInternal bool) ([]TicketMessage, error) {
 	rows, err := r.db.QueryContext(ctx, `
 SELECT
   m.id,
-  m.author_id,
+  COALESCE(m.author_id, 0),
   COALESCE(u.first_name,'') as fn,
   COALESCE(u.last_name,'') as ln,
   m.message,
+  m.visibility,
   m.created_at
 FROM ticket_messages m
-JOIN users u ON u.id = m.author_id
-WHERE m.ticket_id = $1
+LEFT JOIN users u ON u.id = m.author_id
+WHERE m.ticket_id = $1 AND ($2 OR m.visibility = 'public')
 ORDER BY m.created_at ASC, m.id ASC;
-`, ticketID)
+`, ticketID, withInternal)
 	if err != nil {
 		return nil, err
 	}
@@ -340,9 +294,10 @@ ORDER BY m.created_at ASC, m.id ASC;
 			fn       string
 			ln       string
 			msg      string
+			vis      string
 			created  time.Time
 		)
-		if err := rows.Scan(&id, &authorID, &fn, &ln, &msg, &created); err != nil {
+		if err := rows.Scan(&id, &authorID, &fn, &ln, &msg, &vis, &created); err != nil {
 			return nil, err
 		}
 
@@ -352,46 +307,51 @@ ORDER BY m.created_at ASC, m.id ASC;
 		}
 
 		out = append(out, TicketMessage{
-			ID:        id,
-			AuthorID:  authorID,
-			Author:    author,
-			Message:   msg,
-			CreatedAt: created.Format("15:04 02.01.2006"),
+			ID:         id,
+			AuthorID:   authorID,
+			Author:     author,
+			Message:    msg,
+			Visibility: vis,
+			CreatedAt:  created.Format("15:04 02.01.2006"),
 		})
 	}
 	return out, rows.Err()
 }
 
-func (r *TicketsRepo) CloseTicket(ctx context.Context, id int64) (TicketDetail, error) {
-	_, err := r.db.ExecContext(ctx, `
-UPDATE tickets
-SET status = 'closed',
-    closed_at = NOW(),
-    updated_at = NOW()
-WHERE id = $1
-  AND status = 'in_progress';
-`, id)
+func (r *TicketsRepo) CloseTicket(ctx context.Context, id int64, actorID int64) (TicketDetail, error) {
+	return r.Transition(ctx, id, TicketClosed, actorID)
+}
+
+// SaveSupportReply assigns the ticket and posts reply as a public message.
+func (r *TicketsRepo) SaveSupportReply(ctx context.Context, ticketID, assigneeID, authorID int64, reply string) (TicketDetail, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return TicketDetail{}, err
 	}
-	return r.GetTicket(ctx, id)
-}
+	defer func() { _ = tx.Rollback() }()
 
-func (r *TicketsRepo) SaveSupportReply(ctx context.Context, ticketID int64, assigneeID int64, reply string) (TicketDetail, error) {
-	_, err := r.db.ExecContext(ctx, `
+	if err := startTicketWork(ctx, tx, ticketID); err != nil {
+		return TicketDetail{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
 UPDATE tickets
 SET taken_by = $1,
-    support_reply = $2,
-    replied_at = NOW(),
-    status = 'in_progress',
+    awaiting_support = FALSE,
     updated_at = NOW()
-WHERE id = $3
-  AND status IN ('open','in_progress');
-`, assigneeID, reply, ticketID)
-	if err != nil {
+WHERE id = $2;
+`, assigneeID, ticketID); err != nil {
+		return TicketDetail{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO ticket_messages(ticket_id, author_id, message, visibility)
+VALUES ($1, $2, $3, 'public');
+`, ticketID, authorID, reply); err != nil {
 		return TicketDetail{}, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return TicketDetail{}, err
+	}
 	return r.GetTicket(ctx, ticketID)
 }
 
@@ -406,8 +366,8 @@ SELECT
   t.priority,
   t.status,
   t.taken_by,
-  t.support_reply,
-  t.replied_at,
+  r.message,
+  r.created_at,
   u.first_name,
   u.last_name,
   d.name,
@@ -418,6 +378,15 @@ FROM tickets t
 JOIN users u ON u.id = t.user_id
 LEFT JOIN depts d ON d.id = u.dept_id
 LEFT JOIN users u2 ON u2.id = t.taken_by
+LEFT JOIN LATERAL (
+  SELECT m.message, m.created_at
+  FROM ticket_messages m
+  WHERE m.ticket_id = t.id
+    AND m.visibility = 'public'
+    AND m.author_id IS DISTINCT FROM t.user_id
+  ORDER BY m.created_at DESC, m.id DESC
+  LIMIT 1
+) r ON TRUE
 WHERE t.id = $1 AND t.user_id = $2
 LIMIT 1;
 `
@@ -500,3 +469,43 @@ LIMIT 1;
 
 	return t, nil
 }
+
+func (r *TicketsRepo) StatsForUser(ctx context.Context, userID int64) (UserTicketStats, error) {
+	st := UserTicketStats{
+		Raised:   TicketCounts{ByStatus: map[string]int{}},
+		Assigned: TicketCounts{ByStatus: map[string]int{}},
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+SELECT
+  status,
+  COUNT(*) FILTER (WHERE user_id = $1),
+  COUNT(*) FILTER (WHERE taken_by = $1)
+FROM tickets
+WHERE user_id = $1 OR taken_by = $1
+GROUP BY status;
+`, userID)
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			status           string
+			raised, assigned int
+		)
+		if err := rows.Scan(&status, &raised, &assigned); err != nil {
+			return st, err
+		}
+		if raised > 0 {
+			st.Raised.ByStatus[status] = raised
+			st.Raised.Total += raised
+		}
+		if assigned > 0 {
+			st.Assigned.ByStatus[status] = assigned
+			st.Assigned.Total += assigned
+		}
+	}
+	return st, rows.Err()
+}
@@ -0,0 +1,26 @@
+package storage
+
+import "context"
+
+const AuditSourceImport = "import"
+
+// UserChange is one field of an account changed on someone else's behalf.
+type UserChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// recordUserChanges writes changes to user_audit_log. actorID is zero when
+// the change was not made by a signed-in user, e.g. from the CLI.
+func recordUserChanges(ctx context.Context, db execer, userID, actorID int64, source string, changes []UserChange) error {
+	for _, ch := range changes {
+		if _, err := db.ExecContext(ctx, `
+INSERT INTO user_audit_log(user_id, actor_id, source, field, old_value, new_value)
+VALUES ($1, $2, $3, $4, $5, $6);
+`, userID, optID(&actorID), source, ch.Field, nullIfEmpty(ch.Old), nullIfEmpty(ch.New)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
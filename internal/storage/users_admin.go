@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type UserListItem struct {
+	ID                 int64   `json:"id"`
+	Username           string  `json:"username"`
+	Email              string  `json:"email"`
+	Name               string  `json:"name"`
+	FirstName          string  `json:"firstName"`
+	MiddleName         string  `json:"middleName"`
+	LastName           string  `json:"lastName"`
+	Phone              string  `json:"phone"`
+	DeptID             *int64  `json:"deptId,omitempty"`
+	DeptName           *string `json:"deptName,omitempty"`
+	Role               string  `json:"role"`
+	AuthSource         string  `json:"authSource"`
+	Active             bool    `json:"active"`
+	Locked             bool    `json:"locked"`
+	MFAEnabled         bool    `json:"mfaEnabled"`
+	MustChangePassword bool    `json:"mustChangePassword"`
+	RegistrationStatus string  `json:"registrationStatus,omitempty"`
+	CreatedAt          string  `json:"createdAt"`
+}
+
+type SearchUsersParams struct {
+	Q      string
+	Role   string
+	DeptID int64
+	Active *bool
+	// Registration filters by registration status; "none" matches
+	// accounts that are fully registered.
+	Registration string
+	Limit        int
+	Offset       int
+}
+
+func (r *UsersRepo) Search(ctx context.Context, p SearchUsersParams) ([]UserListItem, int, error) {
+	where := []string{}
+	args := []any{}
+	n := 1
+
+	if q := strings.TrimSpace(p.Q); q != "" {
+		where = append(where, fmt.Sprintf(
+			"(u.username ILIKE $%d OR u.email ILIKE $%d OR u.first_name ILIKE $%d OR u.last_name ILIKE $%d OR u.phone ILIKE $%d)",
+			n, n, n, n, n,
+		))
+		args = append(args, "%"+q+"%")
+		n++
+	}
+	if p.Role != "" {
+		where = append(where, fmt.Sprintf("u.role = $%d", n))
+		args = append(args, p.Role)
+		n++
+	}
+	if p.DeptID != 0 {
+		where = append(where, fmt.Sprintf("u.dept_id = $%d", n))
+		args = append(args, p.DeptID)
+		n++
+	}
+	switch p.Registration {
+	case "":
+	case "none":
+		where = append(where, "u.registration_status IS NULL")
+	default:
+		where = append(where, fmt.Sprintf("u.registration_status = $%d", n))
+		args = append(args, p.Registration)
+		n++
+	}
+	if p.Active != nil {
+		if *p.Active {
+			where = append(where, "u.deactivated_at IS NULL")
+		} else {
+			where = append(where, "u.deactivated_at IS NOT NULL")
+		}
+	}
+
+	w := ""
+	if len(where) > 0 {
+		w = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	args = append(args, p.Limit, p.Offset)
+	query := `
+SELECT
+  u.id,
+  u.username,
+  u.email,
+  u.first_name,
+  COALESCE(u.middle_name, ''),
+  u.last_name,
+  COALESCE(u.phone, ''),
+  u.dept_id,
+  d.name,
+  u.role,
+  u.auth_source,
+  u.deactivated_at IS NULL,
+  COALESCE(u.locked_until > NOW(), FALSE),
+  u.totp_enabled_at IS NOT NULL,
+  u.must_change_password,
+  COALESCE(u.registration_status, ''),
+  u.created_at,
+  COUNT(*) OVER ()
+FROM users u
+LEFT JOIN depts d ON d.id = u.dept_id
+` + w + `
+ORDER BY u.last_name, u.first_name, u.id
+LIMIT $` + fmt.Sprint(n) + ` OFFSET $` + fmt.Sprint(n+1) + `;
+`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		out   []UserListItem
+		total int
+	)
+	for rows.Next() {
+		var (
+			u       UserListItem
+			created time.Time
+		)
+		if err := rows.Scan(
+			&u.ID,
+			&u.Username,
+			&u.Email,
+			&u.FirstName,
+			&u.MiddleName,
+			&u.LastName,
+			&u.Phone,
+			&u.DeptID,
+			&u.DeptName,
+			&u.Role,
+			&u.AuthSource,
+			&u.Active,
+			&u.Locked,
+			&u.MFAEnabled,
+			&u.MustChangePassword,
+			&u.RegistrationStatus,
+			&created,
+			&total,
+		); err != nil {
+			return nil, 0, err
+		}
+
+		u.Name = strings.TrimSpace(u.FirstName + " " + u.LastName)
+		u.CreatedAt = created.Format("15:04 02.01.2006")
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if total == 0 && p.Offset > 0 {
+		err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users u `+w+`;`, args[:len(args)-2]...).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return out, total, nil
+}
+
+type AdminUpdateUserParams struct {
+	Role *string
+	// DeptID set to 0 detaches the user from their department.
+	DeptID *int64
+	Phone  *string
+}
+
+func (r *UsersRepo) AdminUpdate(ctx context.Context, id int64, p AdminUpdateUserParams) error {
+	set := []string{}
+	args := []any{}
+	n := 1
+
+	add := func(col string, v any) {
+		set = append(set, fmt.Sprintf("%s = $%d", col, n))
+		args = append(args, v)
+		n++
+	}
+
+	if p.Role != nil {
+		add("role", *p.Role)
+	}
+	if p.DeptID != nil {
+		if *p.DeptID == 0 {
+			add("dept_id", nil)
+		} else {
+			add("dept_id", *p.DeptID)
+		}
+	}
+	if p.Phone != nil {
+		add("phone", nullIfEmpty(*p.Phone))
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	args = append(args, id)
+	q := `UPDATE users SET ` + strings.Join(set, ", ") + `, updated_at = NOW() WHERE id = $` + fmt.Sprint(n) + `;`
+
+	res, err := r.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+// Deactivate blocks the account and revokes every refresh session it holds.
+func (r *UsersRepo) Deactivate(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE users
+SET deactivated_at = COALESCE(deactivated_at, NOW()),
+    updated_at = NOW()
+WHERE id = $1;
+`, id)
+	if err != nil {
+		return err
+	}
+	if err := affectedOrNoRows(res); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *UsersRepo) Reactivate(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE users
+SET deactivated_at = NULL,
+    updated_at = NOW()
+WHERE id = $1;
+`, id)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *UsersRepo) UsernameTaken(ctx context.Context, username string) (bool, error) {
+	var taken bool
+	err := r.db.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM users WHERE lower(username) = lower($1));
+`, username).Scan(&taken)
+	return taken, err
+}
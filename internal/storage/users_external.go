@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const AuthSourceLocal = "local"
+
+var ErrExternalConflict = errors.New("an account with this username or email already exists")
+
+// Accounts that sign in through an external provider keep this marker as
+// their password hash, so bcrypt never matches it.
+const externalPasswordHash = "!external"
+
+type ExternalUserParams struct {
+	Source     string
+	ExternalID string
+	Username   string
+	Email      string
+	FirstName  string
+	MiddleName string
+	LastName   string
+	Phone      string
+	DeptName   string
+	Role       string
+}
+
+// UpsertExternal provisions or refreshes the account behind an external
+// identity. Accounts are matched on (auth_source, external_id) only; an
+// existing account with the same username or email is never taken over and
+// has to be linked by an admin.
+func (r *UsersRepo) UpsertExternal(ctx context.Context, p ExternalUserParams) (*User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var deptID *int64
+	if p.DeptName != "" {
+		var id int64
+		err := tx.QueryRowContext(ctx, `SELECT id FROM depts WHERE lower(name) = lower($1) LIMIT 1;`, p.DeptName).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err == nil {
+			deptID = &id
+		}
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+SELECT id FROM users
+WHERE auth_source = $1 AND external_id = $2
+FOR UPDATE;
+`, p.Source, p.ExternalID).Scan(&id)
+
+	switch {
+	case err == sql.ErrNoRows:
+		if err := checkExternalConflict(ctx, tx, p.Username, p.Email, 0); err != nil {
+			return nil, err
+		}
+		err = tx.QueryRowContext(ctx, `
+INSERT INTO users (username, email, password_hash, first_name, middle_name, last_name, phone, dept_id, role, auth_source, external_id, email_verified_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW())
+RETURNING id;
+`, p.Username, p.Email, externalPasswordHash, p.FirstName, nullIfEmpty(p.MiddleName), p.LastName, nullIfEmpty(p.Phone), deptID, p.Role, p.Source, p.ExternalID).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+	case err != nil:
+		return nil, err
+
+	default:
+		if err := checkExternalConflict(ctx, tx, "", p.Email, id); err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `
+UPDATE users
+SET email = $1,
+    first_name = $2,
+    middle_name = $3,
+    last_name = $4,
+    phone = COALESCE($5, phone),
+    dept_id = COALESCE($6, dept_id),
+    role = $7,
+    email_verified_at = COALESCE(email_verified_at, NOW()),
+    updated_at = NOW()
+WHERE id = $8;
+`, p.Email, p.FirstName, nullIfEmpty(p.MiddleName), p.LastName, nullIfEmpty(p.Phone), deptID, p.Role, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetByID(ctx, id)
+}
+
+func checkExternalConflict(ctx context.Context, tx *sql.Tx, username, email string, exceptID int64) error {
+	var taken bool
+	err := tx.QueryRowContext(ctx, `
+SELECT EXISTS (
+  SELECT 1 FROM users
+  WHERE (username = $1 OR lower(email) = lower($2)) AND id <> $3
+);
+`, username, email, exceptID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrExternalConflict
+	}
+	return nil
+}
+
+// LinkExternal ties an existing account to an external identity, so the
+// next directory or SSO login with that identity signs in as this account.
+func (r *UsersRepo) LinkExternal(ctx context.Context, id int64, source, externalID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var taken bool
+	if err := tx.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM users WHERE auth_source = $1 AND external_id = $2 AND id <> $3);
+`, source, externalID, id).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return ErrExternalConflict
+	}
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE users
+SET auth_source = $1,
+    external_id = $2,
+    updated_at = NOW()
+WHERE id = $3;
+`, source, externalID, id)
+	if err != nil {
+		return err
+	}
+	if err := affectedOrNoRows(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
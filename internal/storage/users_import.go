@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// importedPasswordHash never matches a password; imported accounts get a
+// real one through an invite or the password reset flow.
+const importedPasswordHash = "!imported"
+
+const (
+	ImportCreate    = "create"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+	ImportSkipped   = "skipped"
+	ImportError     = "error"
+)
+
+// ImportUser is one validated row of an import. Empty optional fields leave
+// the existing value untouched.
+type ImportUser struct {
+	Username   string
+	Email      string
+	FirstName  string
+	MiddleName string
+	LastName   string
+	Phone      string
+	Dept       string
+	Role       string
+}
+
+type ImportResult struct {
+	Action  string   `json:"action"`
+	UserID  int64    `json:"userId,omitempty"`
+	Changes []string `json:"changes,omitempty"`
+	// Ignored lists role and email changes left out because the import was
+	// not run with Update.
+	Ignored     []string `json:"ignored,omitempty"`
+	DeptCreated string   `json:"deptCreated,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+type ImportOptions struct {
+	DryRun bool
+	// Update allows changing the role and email of existing accounts.
+	Update bool
+	// ActorID is recorded in the audit log; zero when run from the CLI.
+	ActorID int64
+}
+
+type importRowError struct{ msg string }
+
+func (e importRowError) Error() string { return e.msg }
+
+// Import creates or updates local users matched by username or email. Each
+// row runs under its own savepoint so a failing row does not affect the
+// others; with DryRun everything is rolled back and only the results are
+// returned. Changes to existing accounts are written to the audit log.
+func (r *UsersRepo) Import(ctx context.Context, rows []ImportUser, opts ImportOptions) ([]ImportResult, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	depts := map[string]int64{}
+	out := make([]ImportResult, len(rows))
+
+	for i, row := range rows {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT import_row;`); err != nil {
+			return nil, err
+		}
+
+		res, err := importUser(ctx, tx, row, depts, opts)
+		if err != nil {
+			msg := err.Error()
+			var rowErr importRowError
+			if !errors.As(err, &rowErr) {
+				log.Printf("import user %q: %v", row.Username, err)
+				msg = "database error"
+			}
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_row;`); err != nil {
+				return nil, err
+			}
+			// The dept cache may hold ids created under the rolled back
+			// savepoint.
+			clear(depts)
+			out[i] = ImportResult{Action: ImportError, Error: msg}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT import_row;`); err != nil {
+			return nil, err
+		}
+		if opts.DryRun && res.Action == ImportCreate {
+			res.UserID = 0
+		}
+		out[i] = res
+	}
+
+	if opts.DryRun {
+		return out, nil
+	}
+	return out, tx.Commit()
+}
+
+func importUser(ctx context.Context, tx *sql.Tx, row ImportUser, depts map[string]int64, opts ImportOptions) (ImportResult, error) {
+	var res ImportResult
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT id FROM users
+WHERE lower(username) = lower($1) OR lower(email) = lower($2)
+FOR UPDATE;
+`, row.Username, row.Email)
+	if err != nil {
+		return res, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return res, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	if len(ids) > 1 {
+		return res, importRowError{"username and email belong to different users"}
+	}
+
+	var cur struct {
+		ImportUser
+		authSource    string
+		middle, phone sql.NullString
+		dept          sql.NullInt64
+	}
+	if len(ids) == 1 {
+		res.UserID = ids[0]
+		err := tx.QueryRowContext(ctx, `
+SELECT username, email, first_name, middle_name, last_name, phone, dept_id, role, auth_source
+FROM users
+WHERE id = $1;
+`, res.UserID).Scan(&cur.Username, &cur.Email, &cur.FirstName, &cur.middle, &cur.LastName, &cur.phone, &cur.dept, &cur.Role, &cur.authSource)
+		if err != nil {
+			return res, err
+		}
+		if cur.authSource != AuthSourceLocal {
+			res.Action = ImportSkipped
+			res.Error = "account is managed by " + cur.authSource
+			return res, nil
+		}
+		if !strings.EqualFold(row.Username, cur.Username) {
+			return res, importRowError{"email belongs to user " + cur.Username}
+		}
+	}
+
+	var deptID *int64
+	if row.Dept != "" {
+		id, created, err := importDept(ctx, tx, row.Dept, depts)
+		if err != nil {
+			return res, err
+		}
+		if created {
+			res.DeptCreated = row.Dept
+		}
+		deptID = &id
+	}
+
+	if len(ids) == 0 {
+		role := row.Role
+		if role == "" {
+			role = "user"
+		}
+		err := tx.QueryRowContext(ctx, `
+INSERT INTO users (username, email, password_hash, first_name, middle_name, last_name, phone, dept_id, role)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id;
+`, row.Username, row.Email, importedPasswordHash, row.FirstName, nullIfEmpty(row.MiddleName), row.LastName, nullIfEmpty(row.Phone), deptID, role).Scan(&res.UserID)
+		if err != nil {
+			return res, err
+		}
+		res.Action = ImportCreate
+		return res, nil
+	}
+
+	set := []string{}
+	args := []any{}
+	var audit []UserChange
+	n := 1
+
+	add := func(col, field string, v any, old, new string) {
+		set = append(set, fmt.Sprintf("%s = $%d", col, n))
+		args = append(args, v)
+		n++
+		res.Changes = append(res.Changes, field)
+		audit = append(audit, UserChange{Field: field, Old: old, New: new})
+	}
+
+	if !strings.EqualFold(row.Email, cur.Email) {
+		if opts.Update {
+			add("email", "email", row.Email, cur.Email, row.Email)
+			set = append(set, "pending_email = NULL", "email_verified_at = NULL")
+		} else {
+			res.Ignored = append(res.Ignored, "email")
+		}
+	}
+	if row.FirstName != cur.FirstName {
+		add("first_name", "firstName", row.FirstName, cur.FirstName, row.FirstName)
+	}
+	if row.MiddleName != "" && row.MiddleName != cur.middle.String {
+		add("middle_name", "middleName", row.MiddleName, cur.middle.String, row.MiddleName)
+	}
+	if row.LastName != cur.LastName {
+		add("last_name", "lastName", row.LastName, cur.LastName, row.LastName)
+	}
+	if row.Phone != "" && row.Phone != cur.phone.String {
+		add("phone", "phone", row.Phone, cur.phone.String, row.Phone)
+	}
+	if deptID != nil && (!cur.dept.Valid || cur.dept.Int64 != *deptID) {
+		old := ""
+		if cur.dept.Valid {
+			old = fmt.Sprint(cur.dept.Int64)
+		}
+		add("dept_id", "dept", *deptID, old, fmt.Sprint(*deptID))
+	}
+	if row.Role != "" && row.Role != cur.Role {
+		switch {
+		case !opts.Update:
+			res.Ignored = append(res.Ignored, "role")
+		case res.UserID == opts.ActorID:
+			return res, importRowError{"cannot change your own role"}
+		default:
+			add("role", "role", row.Role, cur.Role, row.Role)
+		}
+	}
+
+	if len(res.Changes) == 0 {
+		res.Action = ImportUnchanged
+		return res, nil
+	}
+
+	args = append(args, res.UserID)
+	q := `UPDATE users SET ` + strings.Join(append(set, "updated_at = NOW()"), ", ") + ` WHERE id = $` + fmt.Sprint(n) + `;`
+	if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+		return res, err
+	}
+	if err := recordUserChanges(ctx, tx, res.UserID, opts.ActorID, AuditSourceImport, audit); err != nil {
+		return res, err
+	}
+	res.Action = ImportUpdate
+	return res, nil
+}
+
+func importDept(ctx context.Context, tx *sql.Tx, name string, cache map[string]int64) (int64, bool, error) {
+	key := strings.ToLower(name)
+	if id, ok := cache[key]; ok {
+		return id, false, nil
+	}
+
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM depts WHERE lower(name) = lower($1) LIMIT 1;`, name).Scan(&id)
+	if err == nil {
+		cache[key] = id
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	if err := tx.QueryRowContext(ctx, `INSERT INTO depts(name) VALUES ($1) RETURNING id;`, name).Scan(&id); err != nil {
+		return 0, false, err
+	}
+	cache[key] = id
+	return id, true, nil
+}
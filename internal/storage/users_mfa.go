@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+)
+
+func (r *UsersRepo) SetTOTPSecret(ctx context.Context, id int64, secret string) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE users
+SET totp_secret = $1,
+    totp_last_step = NULL,
+    updated_at = NOW()
+WHERE id = $2 AND totp_enabled_at IS NULL;
+`, secret, id)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *UsersRepo) EnableTOTP(ctx context.Context, id int64, recoveryHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE users
+SET totp_enabled_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL;
+`, id)
+	if err != nil {
+		return err
+	}
+	if err := affectedOrNoRows(res); err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, id, recoveryHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *UsersRepo) DisableTOTP(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users
+SET totp_secret = NULL,
+    totp_enabled_at = NULL,
+    totp_last_step = NULL,
+    updated_at = NOW()
+WHERE id = $1;
+`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1;`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *UsersRepo) ReplaceRecoveryCodes(ctx context.Context, id int64, recoveryHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := replaceRecoveryCodes(ctx, tx, id, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as consumed; it fails with sql.ErrNoRows when the
+// same or an earlier step was already used.
+func (r *UsersRepo) UseTOTPStep(ctx context.Context, id int64, step int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE users
+SET totp_last_step = $1
+WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1);
+`, step, id)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *UsersRepo) UseRecoveryCode(ctx context.Context, id int64, codeHash string) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+`, id, codeHash)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *UsersRepo) CountRecoveryCodes(ctx context.Context, id int64) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM mfa_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL;
+`, id).Scan(&n)
+	return n, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx execer, id int64, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1;`, id); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO mfa_recovery_codes(user_id, code_hash)
+VALUES ($1, $2);
+`, id, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	RegistrationPendingVerification = "pending_verification"
+	RegistrationPendingApproval     = "pending_approval"
+)
+
+var ErrRegistrationNotPending = errors.New("registration is not awaiting approval")
+
+// PurgeStaleRegistration removes an unverified self-registration holding
+// username or email once it was created before the given time, so an
+// abandoned sign-up does not reserve the address forever.
+func (r *UsersRepo) PurgeStaleRegistration(ctx context.Context, username, email string, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+DELETE FROM users
+WHERE registration_status = $1
+  AND created_at < $2
+  AND (lower(username) = lower($3) OR lower(email) = lower($4));
+`, RegistrationPendingVerification, before, username, email)
+	return err
+}
+
+// DeletePendingRegistration drops a registration that never got as far as
+// sending its verification email.
+func (r *UsersRepo) DeletePendingRegistration(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `
+DELETE FROM users
+WHERE id = $1 AND registration_status = $2;
+`, id, RegistrationPendingVerification)
+	return err
+}
+
+func (r *UsersRepo) CountRegistrationsByIP(ctx context.Context, ip string, since time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM users
+WHERE registration_ip = $1 AND created_at >= $2;
+`, ip, since).Scan(&n)
+	return n, err
+}
+
+// CompleteVerification moves a self-registered account past email
+// verification. It returns the resulting registration status, which is empty
+// once the account can sign in.
+func (r *UsersRepo) CompleteVerification(ctx context.Context, id int64, requireApproval bool) (string, error) {
+	var status sql.NullString
+	err := r.db.QueryRowContext(ctx, `
+UPDATE users
+SET registration_status = CASE WHEN $2 THEN $3 END,
+    updated_at = NOW()
+WHERE id = $1 AND registration_status = $4
+RETURNING registration_status;
+`, id, requireApproval, RegistrationPendingApproval, RegistrationPendingVerification).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return status.String, err
+}
+
+func (r *UsersRepo) ApproveRegistration(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE users
+SET registration_status = NULL,
+    updated_at = NOW()
+WHERE id = $1 AND registration_status = $2;
+`, id, RegistrationPendingApproval)
+	if err != nil {
+		return err
+	}
+	return registrationAffected(res)
+}
+
+// RejectRegistration deletes the pending account; nothing else references it
+// yet, and the username and email become available again.
+func (r *UsersRepo) RejectRegistration(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+DELETE FROM users
+WHERE id = $1 AND registration_status IS NOT NULL;
+`, id)
+	if err != nil {
+		return err
+	}
+	return registrationAffected(res)
+}
+
+func registrationAffected(res sql.Result) error {
+	if err := affectedOrNoRows(res); err == sql.ErrNoRows {
+		return ErrRegistrationNotPending
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ActiveEmailsByRole returns the addresses of active, fully registered users
+// holding one of roles.
+func (r *UsersRepo) ActiveEmailsByRole(ctx context.Context, roles []string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT email FROM users
+WHERE role = ANY($1)
+  AND deactivated_at IS NULL
+  AND registration_status IS NULL
+ORDER BY id;
+`, pq.Array(roles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		out = append(out, email)
+	}
+	return out, rows.Err()
+}
@@ -3,20 +3,33 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strings"
+	"time"
 )
 
 type User struct {
 	ID           int64
 	Username     string
 	Email        string
+	PendingEmail *string
 	PasswordHash string
 	FirstName    string
+	MiddleName   string
 	LastName     string
 	Phone        string
 	DeptID       *int64
 	DeptName     *string
 	Role         string
+	TOTPSecret   string
+	TOTPEnabled  bool
+	FailedLogins int
+	LockedUntil  *time.Time
+	AuthSource   string
+
+	DeactivatedAt      *time.Time
+	MustChangePassword bool
+	RegistrationStatus string
 }
 
 type SupportUser struct {
@@ -32,22 +45,83 @@ func NewUsersRepo(db *sql.DB) *UsersRepo {
 	return &UsersRepo{db: db}
 }
 
-func (r *UsersRepo) GetByLogin(ctx context.Context, login string) (*User, error) {
-	const q = `
-SELECT u.id, u.username, u.email, u.password_hash, u.first_name, u.last_name, u.phone, u.dept_id, d.name, u.role
+const userSelect = `
+SELECT
+  u.id,
+  u.username,
+  u.email,
+  u.pending_email,
+  u.password_hash,
+  u.first_name,
+  u.middle_name,
+  u.last_name,
+  u.phone,
+  u.dept_id,
+  d.name,
+  u.role,
+  u.totp_secret,
+  u.totp_enabled_at,
+  u.failed_logins,
+  CASE WHEN u.locked_until > NOW() THEN u.locked_until END,
+  u.auth_source,
+  u.deactivated_at,
+  u.must_change_password,
+  COALESCE(u.registration_status, '')
 FROM users u
 LEFT JOIN depts d ON d.id = u.dept_id
-WHERE u.username = $1 OR u.email = $1
-LIMIT 1;
 `
-	row := r.db.QueryRowContext(ctx, q, login)
 
-	var u User
-	var deptID sql.NullInt64
-	var deptName sql.NullString
-	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.Phone, &deptID, &deptName, &u.Role); err != nil {
+func scanUser(row *sql.Row) (*User, error) {
+	var (
+		u            User
+		pendingEmail sql.NullString
+		middleName   sql.NullString
+		phone        sql.NullString
+		deptID       sql.NullInt64
+		deptName     sql.NullString
+		totpSecret   sql.NullString
+		totpEnabled  sql.NullTime
+		lockedUntil  sql.NullTime
+		deactivated  sql.NullTime
+	)
+	if err := row.Scan(
+		&u.ID,
+		&u.Username,
+		&u.Email,
+		&pendingEmail,
+		&u.PasswordHash,
+		&u.FirstName,
+		&middleName,
+		&u.LastName,
+		&phone,
+		&deptID,
+		&deptName,
+		&u.Role,
+		&totpSecret,
+		&totpEnabled,
+		&u.FailedLogins,
+		&lockedUntil,
+		&u.AuthSource,
+		&deactivated,
+		&u.MustChangePassword,
+		&u.RegistrationStatus,
+	); err != nil {
 		return nil, err
 	}
+
+	u.MiddleName = middleName.String
+	u.Phone = phone.String
+	u.TOTPSecret = totpSecret.String
+	u.TOTPEnabled = totpEnabled.Valid
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
+	if deactivated.Valid {
+		u.DeactivatedAt = &deactivated.Time
+	}
+	if pendingEmail.Valid {
+		u.PendingEmail = &pendingEmail.String
+	}
 	if deptID.Valid {
 		u.DeptID = &deptID.Int64
 	}
@@ -57,29 +131,18 @@ LIMIT 1;
 	return &u, nil
 }
 
+func (r *UsersRepo) GetByLogin(ctx context.Context, login string) (*User, error) {
+	return scanUser(r.db.QueryRowContext(ctx, userSelect+`
+WHERE u.username = $1 OR u.email = $1
+LIMIT 1;
+`, login))
+}
+
 func (r *UsersRepo) GetByID(ctx context.Context, id int64) (*User, error) {
-	const q = `
-SELECT u.id, u.username, u.email, u.password_hash, u.first_name, u.last_name, u.phone, u.dept_id, d.name, u.role
-FROM users u
-LEFT JOIN depts d ON d.id = u.dept_id
+	return scanUser(r.db.QueryRowContext(ctx, userSelect+`
 WHERE u.id = $1
 LIMIT 1;
-`
-	row := r.db.QueryRowContext(ctx, q, id)
-
-	var u User
-	var deptID sql.NullInt64
-	var deptName sql.NullString
-	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.Phone, &deptID, &deptName, &u.Role); err != nil {
-		return nil, err
-	}
-	if deptID.Valid {
-		u.DeptID = &deptID.Int64
-	}
-	if deptName.Valid {
-		u.DeptName = &deptName.String
-	}
-	return &u, nil
+`, id))
 }
 
 func (r *UsersRepo) CreateSeedSupportIfNotExists(ctx context.Context, username, email, passHash, first, last, phone, deptName string) error {
@@ -160,3 +223,202 @@ ORDER BY last_name, first_name, id;
 
 	return out, rows.Err()
 }
+
+type CreateUserParams struct {
+	Username     string
+	Email        string
+	PasswordHash string
+	FirstName    string
+	MiddleName   string
+	LastName     string
+	Phone        string
+	DeptID       *int64
+	DeptName     string
+	Role         string
+
+	MustChangePassword bool
+	RegistrationStatus string
+	RegistrationIP     string
+}
+
+func (r *UsersRepo) Create(ctx context.Context, p CreateUserParams) (int64, error) {
+	deptID := p.DeptID
+
+	if deptID == nil && p.DeptName != "" {
+		const q = `SELECT id FROM depts WHERE name = $1 LIMIT 1;`
+		var id int64
+		if err := r.db.QueryRowContext(ctx, q, p.DeptName).Scan(&id); err != nil {
+			if err == sql.ErrNoRows {
+				return 0, fmt.Errorf("dept %q not found", p.DeptName)
+			}
+			return 0, err
+		}
+		deptID = &id
+	}
+
+	const q = `
+INSERT INTO users (username, email, password_hash, first_name, middle_name, last_name, phone, dept_id, role, must_change_password, registration_status, registration_ip)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+RETURNING id;
+`
+	var id int64
+	err := r.db.QueryRowContext(ctx, q, p.Username, p.Email, p.PasswordHash, p.FirstName, nullIfEmpty(p.MiddleName), p.LastName, nullIfEmpty(p.Phone), deptID, p.Role, p.MustChangePassword, nullIfEmpty(p.RegistrationStatus), nullIfEmpty(p.RegistrationIP)).Scan(&id)
+	return id, err
+}
+
+func (r *UsersRepo) SetPasswordByLogin(ctx context.Context, login, passHash string) error {
+	const q = `
+UPDATE users
+SET password_hash = $1,
+    must_change_password = FALSE,
+    updated_at = NOW()
+WHERE username = $2 OR email = $2;
+`
+	res, err := r.db.ExecContext(ctx, q, passHash, login)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *UsersRepo) SetRoleByLogin(ctx context.Context, login, role string) error {
+	const q = `
+UPDATE users
+SET role = $1,
+    updated_at = NOW()
+WHERE username = $2 OR email = $2;
+`
+	res, err := r.db.ExecContext(ctx, q, role, login)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func affectedOrNoRows(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type UpdateProfileParams struct {
+	FirstName  *string
+	MiddleName *string
+	LastName   *string
+	Phone      *string
+}
+
+func (r *UsersRepo) UpdateProfile(ctx context.Context, id int64, p UpdateProfileParams) error {
+	set := []string{}
+	args := []any{}
+	n := 1
+
+	add := func(col string, v any) {
+		set = append(set, fmt.Sprintf("%s = $%d", col, n))
+		args = append(args, v)
+		n++
+	}
+
+	if p.FirstName != nil {
+		add("first_name", *p.FirstName)
+	}
+	if p.MiddleName != nil {
+		add("middle_name", nullIfEmpty(*p.MiddleName))
+	}
+	if p.LastName != nil {
+		add("last_name", *p.LastName)
+	}
+	if p.Phone != nil {
+		add("phone", nullIfEmpty(*p.Phone))
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	args = append(args, id)
+	q := `UPDATE users SET ` + strings.Join(set, ", ") + `, updated_at = NOW() WHERE id = $` + fmt.Sprint(n) + `;`
+
+	res, err := r.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *UsersRepo) SetPassword(ctx context.Context, id int64, passHash string) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE users
+SET password_hash = $1,
+    must_change_password = FALSE,
+    updated_at = NOW()
+WHERE id = $2;
+`, passHash, id)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
+
+func (r *UsersRepo) EmailTaken(ctx context.Context, email string, exceptID int64) (bool, error) {
+	var taken bool
+	err := r.db.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1) AND id <> $2);
+`, email, exceptID).Scan(&taken)
+	return taken, err
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// RegisterLoginFailure bumps the failure counter and locks the account for
+// lockout once maxFailures consecutive failures are reached.
+func (r *UsersRepo) RegisterLoginFailure(ctx context.Context, id int64, maxFailures int, lockout time.Duration) (*time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+UPDATE users
+SET failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+    locked_until = CASE WHEN failed_logins + 1 >= $2 THEN NOW() + make_interval(secs => $3) ELSE locked_until END
+WHERE id = $1
+RETURNING CASE WHEN locked_until > NOW() THEN locked_until END;
+`, id, maxFailures, lockout.Seconds()).Scan(&lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		return &lockedUntil.Time, nil
+	}
+	return nil, nil
+}
+
+func (r *UsersRepo) ResetLoginFailures(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE users
+SET failed_logins = 0,
+    locked_until = NULL
+WHERE id = $1 AND (failed_logins <> 0 OR locked_until IS NOT NULL);
+`, id)
+	return err
+}
+
+func (r *UsersRepo) Unlock(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE users
+SET failed_logins = 0,
+    locked_until = NULL,
+    updated_at = NOW()
+WHERE id = $1;
+`, id)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(res)
+}
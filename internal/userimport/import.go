@@ -0,0 +1,140 @@
+package userimport
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"komiac-support-backend/internal/auth"
+	"komiac-support-backend/internal/storage"
+)
+
+type Row struct {
+	Line     int    `json:"line"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	storage.ImportResult
+}
+
+type Report struct {
+	DryRun       bool     `json:"dryRun"`
+	Rows         []Row    `json:"rows"`
+	Created      int      `json:"created"`
+	Updated      int      `json:"updated"`
+	Unchanged    int      `json:"unchanged"`
+	Skipped      int      `json:"skipped"`
+	Failed       int      `json:"failed"`
+	DeptsCreated []string `json:"deptsCreated"`
+}
+
+// Run validates records and applies the valid ones. Invalid rows are
+// reported and skipped; they never reach the database.
+func Run(ctx context.Context, users *storage.UsersRepo, recs []Record, opts storage.ImportOptions) (Report, error) {
+	rep := Report{DryRun: opts.DryRun, Rows: make([]Row, len(recs)), DeptsCreated: make([]string, 0)}
+
+	var (
+		valid   []storage.ImportUser
+		index   []int
+		byLogin = map[string]int{}
+		byEmail = map[string]int{}
+	)
+	for i, rec := range recs {
+		u, msg := validate(rec)
+		rep.Rows[i] = Row{Line: rec.Line, Username: u.Username, Email: u.Email}
+
+		if msg == "" {
+			if line, ok := byLogin[strings.ToLower(u.Username)]; ok {
+				msg = "duplicate username, see line " + strconv.Itoa(line)
+			} else if line, ok := byEmail[strings.ToLower(u.Email)]; ok {
+				msg = "duplicate email, see line " + strconv.Itoa(line)
+			}
+		}
+		if msg != "" {
+			rep.Rows[i].ImportResult = storage.ImportResult{Action: storage.ImportError, Error: msg}
+			continue
+		}
+
+		byLogin[strings.ToLower(u.Username)] = rec.Line
+		byEmail[strings.ToLower(u.Email)] = rec.Line
+		valid = append(valid, u)
+		index = append(index, i)
+	}
+
+	if len(valid) > 0 {
+		results, err := users.Import(ctx, valid, opts)
+		if err != nil {
+			return Report{}, err
+		}
+		for j, res := range results {
+			rep.Rows[index[j]].ImportResult = res
+		}
+	}
+
+	for _, row := range rep.Rows {
+		switch row.Action {
+		case storage.ImportCreate:
+			rep.Created++
+		case storage.ImportUpdate:
+			rep.Updated++
+		case storage.ImportUnchanged:
+			rep.Unchanged++
+		case storage.ImportSkipped:
+			rep.Skipped++
+		default:
+			rep.Failed++
+		}
+		if row.DeptCreated != "" {
+			rep.DeptsCreated = append(rep.DeptsCreated, row.DeptCreated)
+		}
+	}
+	return rep, nil
+}
+
+func validate(rec Record) (storage.ImportUser, string) {
+	f := rec.Fields
+	u := storage.ImportUser{
+		Username:   f[colUsername],
+		Email:      f[colEmail],
+		FirstName:  f[colFirstName],
+		MiddleName: f[colMiddleName],
+		LastName:   f[colLastName],
+		Phone:      f[colPhone],
+		Dept:       f[colDept],
+		Role:       strings.ToLower(f[colRole]),
+	}
+
+	// ФИО comes as "Фамилия Имя Отчество"; explicit columns win.
+	if full := strings.Fields(f[colFullName]); len(full) > 0 {
+		if u.LastName == "" {
+			u.LastName = full[0]
+		}
+		if u.FirstName == "" && len(full) > 1 {
+			u.FirstName = full[1]
+		}
+		if u.MiddleName == "" && len(full) > 2 {
+			u.MiddleName = strings.Join(full[2:], " ")
+		}
+	}
+
+	switch {
+	case u.Username == "" || len(u.Username) > 50:
+		return u, "username is required (max 50 chars)"
+	case strings.Contains(u.Username, "@"):
+		return u, "username must not contain @"
+	case !auth.ValidEmail(u.Email):
+		return u, "invalid email"
+	case u.FirstName == "" || u.LastName == "" || runes(u.FirstName) > 50 || runes(u.LastName) > 50 || runes(u.MiddleName) > 50:
+		return u, "first and last name are required (max 50 chars)"
+	case len(u.Phone) > 20:
+		return u, "phone too long"
+	case runes(u.Dept) > 100:
+		return u, "dept too long (max 100 chars)"
+	case u.Role != "" && !auth.ValidRole(u.Role):
+		return u, "unknown role " + u.Role
+	}
+	return u, ""
+}
+
+func runes(s string) int {
+	return len([]rune(s))
+}
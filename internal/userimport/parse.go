@@ -0,0 +1,224 @@
+package userimport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const MaxRows = 5000
+
+var ErrUnsupportedFormat = errors.New("unsupported file type, expected .csv or .xlsx")
+
+// Record is one data row of the file; Line is its 1-based line (CSV) or row
+// (XLSX) number, so reports point at what the user sees in the editor.
+type Record struct {
+	Line   int
+	Fields map[string]string
+}
+
+const (
+	colUsername   = "username"
+	colEmail      = "email"
+	colFirstName  = "first_name"
+	colMiddleName = "middle_name"
+	colLastName   = "last_name"
+	colFullName   = "full_name"
+	colPhone      = "phone"
+	colDept       = "dept"
+	colRole       = "role"
+)
+
+// headerAliases maps normalised header names, including the Russian ones
+// found in HR exports, to columns.
+var headerAliases = map[string]string{
+	"username":          colUsername,
+	"login":             colUsername,
+	"логин":             colUsername,
+	"учетная_запись":    colUsername,
+	"учётная_запись":    colUsername,
+	"email":             colEmail,
+	"e_mail":            colEmail,
+	"mail":              colEmail,
+	"почта":             colEmail,
+	"эл_почта":          colEmail,
+	"электронная_почта": colEmail,
+	"first_name":        colFirstName,
+	"firstname":         colFirstName,
+	"имя":               colFirstName,
+	"middle_name":       colMiddleName,
+	"middlename":        colMiddleName,
+	"отчество":          colMiddleName,
+	"last_name":         colLastName,
+	"lastname":          colLastName,
+	"фамилия":           colLastName,
+	"full_name":         colFullName,
+	"fullname":          colFullName,
+	"fio":               colFullName,
+	"фио":               colFullName,
+	"phone":             colPhone,
+	"телефон":           colPhone,
+	"dept":              colDept,
+	"department":        colDept,
+	"отдел":             colDept,
+	"подразделение":     colDept,
+	"role":              colRole,
+	"роль":              colRole,
+}
+
+// Parse reads a CSV or XLSX file, picking the format from the file name.
+func Parse(name string, r io.Reader) ([]Record, error) {
+	var (
+		rows [][]string
+		err  error
+	)
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".txt":
+		rows, err = readCSV(r)
+	case ".xlsx":
+		rows, err = readXLSX(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return records(rows)
+}
+
+func readCSV(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		// Excel and 1C save CSV in the ANSI code page unless told otherwise.
+		if data, err = charmap.Windows1251.NewDecoder().Bytes(data); err != nil {
+			return nil, err
+		}
+	}
+
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.Comma = sniffDelimiter(string(first))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	return rows, nil
+}
+
+func sniffDelimiter(line string) rune {
+	best, n := ',', strings.Count(line, ",")
+	for _, d := range []rune{';', '\t'} {
+		if c := strings.Count(line, string(d)); c > n {
+			best, n = d, c
+		}
+	}
+	return best
+}
+
+func readXLSX(r io.Reader) ([][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("xlsx: workbook has no sheets")
+	}
+	rows, err := f.GetRows(sheets[0])
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: %w", err)
+	}
+	return rows, nil
+}
+
+func records(rows [][]string) ([]Record, error) {
+	head := -1
+	for i, row := range rows {
+		if !blank(row) {
+			head = i
+			break
+		}
+	}
+	if head < 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	cols := make([]string, len(rows[head]))
+	seen := map[string]bool{}
+	for i, h := range rows[head] {
+		col, ok := headerAliases[normalizeHeader(h)]
+		if !ok {
+			continue
+		}
+		if seen[col] {
+			return nil, fmt.Errorf("column %q appears more than once", col)
+		}
+		seen[col] = true
+		cols[i] = col
+	}
+
+	var missing []string
+	for _, col := range []string{colUsername, colEmail} {
+		if !seen[col] {
+			missing = append(missing, col)
+		}
+	}
+	if !seen[colFullName] && (!seen[colFirstName] || !seen[colLastName]) {
+		missing = append(missing, colLastName+", "+colFirstName+" or "+colFullName)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing columns: %s", strings.Join(missing, "; "))
+	}
+
+	var out []Record
+	for i := head + 1; i < len(rows); i++ {
+		if blank(rows[i]) {
+			continue
+		}
+		if len(out) == MaxRows {
+			return nil, fmt.Errorf("too many rows (max %d)", MaxRows)
+		}
+
+		rec := Record{Line: i + 1, Fields: map[string]string{}}
+		for j, v := range rows[i] {
+			if j < len(cols) && cols[j] != "" {
+				rec.Fields[cols[j]] = strings.TrimSpace(v)
+			}
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func normalizeHeader(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	h = strings.NewReplacer(" ", "_", "-", "_", ".", "").Replace(h)
+	return h
+}
+
+func blank(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}